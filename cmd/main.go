@@ -9,13 +9,15 @@ import (
 	"github.com/urfave/cli/v2"
 )
 
-//nolintlint:gochecknoglobals // we need a global list of commands
-var commands = []*cli.Command{
-	new(action.List).Cmd(),
-	new(action.DeleteByID).Cmd(),
-	new(action.CleanupByName).Cmd(),
-	new(action.Publication).Cmd(),
-	version(),
+// commands returns a fresh list of commands, actions keep state between runs.
+func commands() []*cli.Command {
+	return []*cli.Command{
+		new(action.List).Cmd(),
+		new(action.DeleteByID).Cmd(),
+		new(action.CleanupByName).Cmd(),
+		new(action.Publication).Cmd(),
+		version(),
+	}
 }
 
 func main() {
@@ -49,7 +51,8 @@ func CreateApp() *cli.App {
 	c.Suggest = true
 	c.Usage = "Image management for openstack"
 	c.Description = "This tool helps you housekeeping your openstack images.!\n"
-	c.Commands = commands
+	c.Flags = action.GlobalFlags()
+	c.Commands = commands()
 	c.CommandNotFound = command404
 
 	return c
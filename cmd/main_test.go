@@ -0,0 +1,760 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/hornwind/openstack-image-keeper/pkg/fakecloud"
+	log "github.com/hornwind/openstack-image-keeper/pkg/logging"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/yaml.v3"
+)
+
+type AppSuite struct {
+	suite.Suite
+	cloud *fakecloud.Server
+	out   *bytes.Buffer
+}
+
+func TestApp(t *testing.T) {
+	suite.Run(t, &AppSuite{})
+}
+
+func (as *AppSuite) SetupTest() {
+	as.cloud = fakecloud.NewServer(as.T(), "ru-1", "ru-3")
+	as.cloud.Setenv(as.T())
+	as.out = &bytes.Buffer{}
+	as.T().Cleanup(func() {
+		log.GetLogger().SetOutput(os.Stdout)
+	})
+}
+
+func (as *AppSuite) run(args ...string) error {
+	app := CreateApp()
+	app.Writer = as.out
+
+	return app.Run(append([]string{"housekeeper"}, args...))
+}
+
+func (as *AppSuite) imageIDs(region string) []string {
+	ids := []string{}
+	for _, i := range as.cloud.Store(region).Images() {
+		ids = append(ids, i.ID)
+	}
+	return ids
+}
+
+// chdir changes working directory until the end of test.
+func (as *AppSuite) chdir(dir string) {
+	pwd, err := os.Getwd()
+	as.Require().NoError(err)
+	as.Require().NoError(os.Chdir(dir))
+	as.T().Cleanup(func() {
+		os.Chdir(pwd) //nolint:errcheck // best effort
+	})
+}
+
+// initRepo creates git repository with n empty commits in working directory
+// and returns their hashes from HEAD.
+func (as *AppSuite) initRepo(n int) []string {
+	dir := as.T().TempDir()
+	repo, err := git.PlainInit(dir, false)
+	as.Require().NoError(err)
+	wt, err := repo.Worktree()
+	as.Require().NoError(err)
+
+	commits := make([]string, n)
+	for i := n - 1; i >= 0; i-- {
+		hash, err := wt.Commit("commit", &git.CommitOptions{
+			AllowEmptyCommits: true,
+			Author:            &object.Signature{Name: "ci", Email: "ci@example.com", When: time.Now()},
+		})
+		as.Require().NoError(err)
+		commits[i] = hash.String()
+	}
+	as.chdir(dir)
+
+	return commits
+}
+
+func (as *AppSuite) TestList() {
+	as.cloud.PageSize = 1
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:        "2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now(),
+	}, images.Image{
+		ID:        "8d5c3a56-0a5d-4b8e-a7c0-3f7f1c1b2e22",
+		Name:      "runner",
+		CreatedAt: time.Now().Add(-time.Hour),
+	}, images.Image{
+		ID:        "4c1d2f3e-5a6b-4c7d-8e9f-0a1b2c3d4e55",
+		Name:      "foreign",
+		Owner:     "another-project",
+		CreatedAt: time.Now(),
+	})
+
+	err := as.run("list")
+
+	as.Require().NoError(err)
+	as.Assert().Contains(as.out.String(), "Name: gitlab_dev\nID: 2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11")
+	as.Assert().Contains(as.out.String(), "Name: runner\nID: 8d5c3a56-0a5d-4b8e-a7c0-3f7f1c1b2e22")
+	as.Assert().NotContains(as.out.String(), "foreign")
+}
+
+func (as *AppSuite) TestListTemplateRegion() {
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:        "2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now(),
+	})
+
+	err := as.run("list", "--template", `[{{ region }}] {{ .ID }}{{ "\n" }}`)
+
+	as.Require().NoError(err)
+	as.Assert().Equal("[ru-1] 2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11\n", as.out.String())
+}
+
+func (as *AppSuite) TestListFilters() {
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:         "2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11",
+		Name:       "gitlab_dev",
+		Tags:       []string{"master"},
+		Properties: map[string]interface{}{"os_distro": "ubuntu"},
+		CreatedAt:  time.Now().Add(-time.Hour),
+	}, images.Image{
+		ID:        "8d5c3a56-0a5d-4b8e-a7c0-3f7f1c1b2e22",
+		Name:      "gitlab_dev",
+		Tags:      []string{"master"},
+		CreatedAt: time.Now().Add(-72 * time.Hour),
+	}, images.Image{
+		ID:         "4c1d2f3e-5a6b-4c7d-8e9f-0a1b2c3d4e55",
+		Name:       "runner",
+		Properties: map[string]interface{}{"os_distro": "ubuntu"},
+		CreatedAt:  time.Now(),
+	})
+
+	err := as.run("list", "--tag", "master", "--created-after", "2d", "--property", "os_distro=ubuntu",
+		"--sort", "created_at:asc", "--template", "{{ .ID }}\n")
+
+	as.Require().NoError(err)
+	as.Assert().Equal("2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11\n", as.out.String())
+}
+
+func (as *AppSuite) TestListAllRegions() {
+	as.cloud.AddImages("ru-3", images.Image{
+		ID:        "2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now(),
+	})
+
+	err := as.run("list", "--regions", "all")
+
+	as.Require().NoError(err)
+	as.Assert().Contains(as.out.String(), "Region ru-1:\nRegion ru-3:\nName: gitlab_dev")
+	as.Assert().Contains(as.out.String(), "Regions:\n  ru-1: ok\n  ru-3: ok\n")
+}
+
+func (as *AppSuite) TestListUnknownRegion() {
+	err := as.run("list", "--regions", "ru-1,ru-9")
+
+	as.Require().EqualError(err, "failed in 1 of 2 regions: ru-9")
+}
+
+func (as *AppSuite) TestListJSON() {
+	for _, region := range []string{"ru-1", "ru-3"} {
+		as.cloud.AddImages(region, images.Image{
+			ID:         "2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11",
+			Name:       "gitlab_dev",
+			Tags:       []string{"master"},
+			Properties: map[string]interface{}{"os_distro": "ubuntu"},
+			CreatedAt:  time.Now(),
+		})
+	}
+
+	err := as.run("--output", "json", "list", "--regions", "all")
+
+	as.Require().NoError(err)
+	var doc struct {
+		Images []struct {
+			Region     string            `json:"region"`
+			ID         string            `json:"id"`
+			Tags       []string          `json:"tags"`
+			Properties map[string]string `json:"properties"`
+		} `json:"images"`
+	}
+	as.Require().NoError(json.Unmarshal(as.out.Bytes(), &doc))
+	as.Require().Len(doc.Images, 2)
+	as.Assert().Equal("ru-1", doc.Images[0].Region)
+	as.Assert().Equal("ru-3", doc.Images[1].Region)
+	as.Assert().Equal("2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11", doc.Images[0].ID)
+	as.Assert().Equal([]string{"master"}, doc.Images[0].Tags)
+	as.Assert().Equal("ubuntu", doc.Images[0].Properties["os_distro"])
+}
+
+func (as *AppSuite) TestFailedRegionsJSON() {
+	as.initRepo(1)
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:        "2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now(),
+	})
+
+	for _, args := range [][]string{
+		{"list", "--regions", "ru-1,ru-9"},
+		{"cleanup", "--dry-run", "--regions", "ru-1,ru-9", "gitlab_dev"},
+		{"publish", "--dry-run", "--regions", "ru-1,ru-9", "2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11"},
+	} {
+		as.out.Reset()
+
+		err := as.run(append([]string{"--output", "json"}, args...)...)
+
+		as.Require().EqualError(err, "failed in 1 of 2 regions: ru-9", args)
+		var doc struct {
+			FailedRegions []struct {
+				Region string `json:"region"`
+				Error  string `json:"error"`
+			} `json:"failed_regions"`
+		}
+		as.Require().NoError(json.Unmarshal(as.out.Bytes(), &doc), args)
+		as.Require().Len(doc.FailedRegions, 1, args)
+		as.Assert().Equal("ru-9", doc.FailedRegions[0].Region, args)
+		as.Assert().NotEmpty(doc.FailedRegions[0].Error, args)
+	}
+}
+
+func (as *AppSuite) TestUnknownOutput() {
+	err := as.run("--output", "xml", "list")
+
+	as.Require().EqualError(err, `unknown output format "xml", expected text, json or yaml`)
+}
+
+func (as *AppSuite) TestListCloudsYAML() {
+	dir := as.T().TempDir()
+	cloudsYAML := `clouds:
+  fake:
+    auth:
+      auth_url: ` + as.cloud.URL + `/v3
+      username: housekeeper
+      project_id: ` + fakecloud.ProjectID + `
+      user_domain_name: Default
+    region_name: ru-3
+`
+	secureYAML := `clouds:
+  fake:
+    auth:
+      password: secret
+`
+	as.Require().NoError(os.WriteFile(filepath.Join(dir, "clouds.yaml"), []byte(cloudsYAML), 0o600))
+	as.Require().NoError(os.WriteFile(filepath.Join(dir, "secure.yaml"), []byte(secureYAML), 0o600))
+	as.chdir(dir)
+	for _, env := range []string{"OS_AUTH_URL", "OS_USERNAME", "OS_PASSWORD", "OS_PROJECT_ID", "OS_DOMAIN_NAME", "OS_REGION_NAME"} {
+		as.T().Setenv(env, "")
+	}
+	as.cloud.AddImages("ru-3", images.Image{
+		ID:        "2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now(),
+	})
+
+	err := as.run("--os-cloud", "fake", "list")
+
+	as.Require().NoError(err)
+	as.Assert().Contains(as.out.String(), "Name: gitlab_dev")
+}
+
+func (as *AppSuite) TestDelete() {
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:        "f25148bb-fc89-4787-abfa-4889e455c3f8",
+		CreatedAt: time.Now(),
+	}, images.Image{
+		ID:        "8b2d978b-da7f-4ddd-839e-27fbbecb4de2",
+		CreatedAt: time.Now(),
+	})
+
+	err := as.run("delete", "f25148bb-fc89-4787-abfa-4889e455c3f8")
+
+	as.Require().NoError(err)
+	as.Assert().Equal([]string{"8b2d978b-da7f-4ddd-839e-27fbbecb4de2"}, as.imageIDs("ru-1"))
+}
+
+func (as *AppSuite) TestDeleteContinueOnErrorJSON() {
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:        "f25148bb-fc89-4787-abfa-4889e455c3f8",
+		CreatedAt: time.Now(),
+	})
+
+	err := as.run("-o", "json", "delete", "--continue-on-error",
+		"00000000-0000-0000-0000-000000000000", "f25148bb-fc89-4787-abfa-4889e455c3f8")
+
+	as.Require().EqualError(err, "failed to delete 1 images")
+	as.Assert().Empty(as.imageIDs("ru-1"))
+	var doc struct {
+		Deleted []string `json:"deleted"`
+		Failed  []struct {
+			ID     string `json:"id"`
+			Status int    `json:"status"`
+		} `json:"failed"`
+	}
+	as.Require().NoError(json.Unmarshal(as.out.Bytes(), &doc), "stdout must be a single document")
+	as.Assert().Equal([]string{"f25148bb-fc89-4787-abfa-4889e455c3f8"}, doc.Deleted)
+	as.Require().Len(doc.Failed, 1)
+	as.Assert().Equal("00000000-0000-0000-0000-000000000000", doc.Failed[0].ID)
+	as.Assert().Equal(404, doc.Failed[0].Status)
+}
+
+func (as *AppSuite) TestDeleteStopOnErrorJSON() {
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:        "f25148bb-fc89-4787-abfa-4889e455c3f8",
+		CreatedAt: time.Now(),
+	})
+
+	err := as.run("-o", "json", "delete",
+		"00000000-0000-0000-0000-000000000000", "f25148bb-fc89-4787-abfa-4889e455c3f8")
+
+	as.Require().Error(err)
+	as.Assert().Len(as.imageIDs("ru-1"), 1)
+	var doc struct {
+		Deleted []string `json:"deleted"`
+		Failed  []struct {
+			ID     string `json:"id"`
+			Status int    `json:"status"`
+		} `json:"failed"`
+		Skipped []string `json:"skipped"`
+	}
+	as.Require().NoError(json.Unmarshal(as.out.Bytes(), &doc), "stdout must be a single document")
+	as.Assert().Empty(doc.Deleted)
+	as.Require().Len(doc.Failed, 1)
+	as.Assert().Equal("00000000-0000-0000-0000-000000000000", doc.Failed[0].ID)
+	as.Assert().Equal(404, doc.Failed[0].Status)
+	as.Assert().Equal([]string{"f25148bb-fc89-4787-abfa-4889e455c3f8"}, doc.Skipped)
+}
+
+func (as *AppSuite) TestDeleteRetries() {
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:        "f25148bb-fc89-4787-abfa-4889e455c3f8",
+		CreatedAt: time.Now(),
+	})
+	as.cloud.FailImageRequests(1, http.StatusServiceUnavailable, nil)
+	as.cloud.FailImageRequests(1, http.StatusTooManyRequests, http.Header{"Retry-After": {"0"}})
+
+	err := as.run("--retry-backoff", "1ms", "delete", "f25148bb-fc89-4787-abfa-4889e455c3f8")
+
+	as.Require().NoError(err)
+	as.Assert().Empty(as.imageIDs("ru-1"))
+}
+
+func (as *AppSuite) TestDeleteRetriesExhausted() {
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:        "f25148bb-fc89-4787-abfa-4889e455c3f8",
+		CreatedAt: time.Now(),
+	})
+	as.cloud.FailImageRequests(2, http.StatusInternalServerError, nil)
+
+	err := as.run("--retries", "1", "--retry-backoff", "1ms", "delete", "f25148bb-fc89-4787-abfa-4889e455c3f8")
+
+	as.Require().Error(err)
+	as.Assert().Len(as.imageIDs("ru-1"), 1)
+}
+
+func (as *AppSuite) TestNegativeRetries() {
+	as.Require().EqualError(as.run("--retries", "-1", "list"), "retries must not be negative, got -1")
+}
+
+func (as *AppSuite) TestCleanup() {
+	commits := as.initRepo(3)
+	for _, region := range []string{"ru-1", "ru-3"} {
+		as.cloud.AddImages(region, images.Image{
+			ID:        "b9551daf-10df-4739-82a0-b7efc687e9c6",
+			Name:      "gitlab_dev",
+			Tags:      []string{commits[0], "master"},
+			CreatedAt: time.Now(),
+		}, images.Image{
+			ID:        "a66e2ab7-3de5-4cf3-bd24-104ccb511c8c",
+			Name:      "gitlab_dev",
+			Tags:      []string{commits[1], "master"},
+			CreatedAt: time.Now().Add(-time.Hour),
+		}, images.Image{
+			ID:         "5beb9780-8eed-480f-807f-7a99c89174f2",
+			Name:       "gitlab_dev",
+			Visibility: images.ImageVisibilityPublic,
+			CreatedAt:  time.Now().Add(-2 * time.Hour),
+		})
+	}
+
+	err := as.run("cleanup", "--regions", "ru-1,ru-3", "gitlab_dev")
+
+	as.Require().NoError(err)
+	for _, region := range []string{"ru-1", "ru-3"} {
+		as.Assert().Equal([]string{
+			"b9551daf-10df-4739-82a0-b7efc687e9c6",
+			"5beb9780-8eed-480f-807f-7a99c89174f2",
+		}, as.imageIDs(region))
+	}
+}
+
+func (as *AppSuite) TestCleanupAge() {
+	as.initRepo(1)
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:        "b9551daf-10df-4739-82a0-b7efc687e9c6",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now(),
+	}, images.Image{
+		ID:        "a66e2ab7-3de5-4cf3-bd24-104ccb511c8c",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now().Add(-24 * time.Hour),
+	}, images.Image{
+		ID:        "04f24cb4-beb0-4d87-b67a-d4834fba08ab",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now().Add(-10 * 24 * time.Hour),
+	}, images.Image{
+		ID:        "5beb9780-8eed-480f-807f-7a99c89174f2",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now().Add(-40 * 24 * time.Hour),
+	})
+
+	err := as.run("cleanup", "--min-age", "72h", "--max-age", "30d", "--keep-last", "3", "--explain", "gitlab_dev")
+
+	as.Require().NoError(err)
+	as.Assert().Equal([]string{
+		"b9551daf-10df-4739-82a0-b7efc687e9c6",
+		"a66e2ab7-3de5-4cf3-bd24-104ccb511c8c",
+		"04f24cb4-beb0-4d87-b67a-d4834fba08ab",
+	}, as.imageIDs("ru-1"))
+	as.Assert().Contains(as.out.String(), "Reason: younger than 3d (rule age)")
+	as.Assert().Contains(as.out.String(), "Reason: older than 30d (rule age)")
+}
+
+func (as *AppSuite) TestCleanupAgeInvalid() {
+	as.Require().Error(as.run("cleanup", "--min-age", "3x", "gitlab_dev"))
+}
+
+func (as *AppSuite) TestCleanupYAML() {
+	commits := as.initRepo(2)
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:        "b9551daf-10df-4739-82a0-b7efc687e9c6",
+		Name:      "gitlab_dev",
+		Tags:      []string{commits[0]},
+		CreatedAt: time.Now(),
+	}, images.Image{
+		ID:        "a66e2ab7-3de5-4cf3-bd24-104ccb511c8c",
+		Name:      "gitlab_dev",
+		Tags:      []string{commits[1]},
+		CreatedAt: time.Now().Add(-time.Hour),
+	})
+
+	err := as.run("-o", "yaml", "cleanup", "--dry-run", "--max-age", "30d", "gitlab_dev")
+
+	as.Require().NoError(err)
+	var doc struct {
+		Name    string `yaml:"name"`
+		DryRun  bool   `yaml:"dry_run"`
+		Kept    []struct{ ID, Rule string }
+		Deleted []struct{ ID, Reason string }
+	}
+	as.Require().NoError(yaml.Unmarshal(as.out.Bytes(), &doc))
+	as.Assert().Equal("gitlab_dev", doc.Name)
+	as.Assert().True(doc.DryRun)
+	as.Require().Len(doc.Kept, 1)
+	as.Assert().Equal("b9551daf-10df-4739-82a0-b7efc687e9c6", doc.Kept[0].ID)
+	as.Assert().Equal("latest", doc.Kept[0].Rule)
+	as.Require().Len(doc.Deleted, 1)
+	as.Assert().Equal("a66e2ab7-3de5-4cf3-bd24-104ccb511c8c", doc.Deleted[0].ID)
+	as.Assert().Equal("superseded by b9551daf-10df-4739-82a0-b7efc687e9c6", doc.Deleted[0].Reason)
+	as.Assert().Len(as.imageIDs("ru-1"), 2)
+}
+
+func (as *AppSuite) TestCleanupInUse() {
+	commits := as.initRepo(1)
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:        "b9551daf-10df-4739-82a0-b7efc687e9c6",
+		Name:      "gitlab_dev",
+		Tags:      []string{commits[0]},
+		CreatedAt: time.Now(),
+	}, images.Image{
+		ID:        "a66e2ab7-3de5-4cf3-bd24-104ccb511c8c",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now().Add(-time.Hour),
+	}, images.Image{
+		ID:        "5beb9780-8eed-480f-807f-7a99c89174f2",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now().Add(-2 * time.Hour),
+	}, images.Image{
+		ID:        "04f24cb4-beb0-4d87-b67a-d4834fba08ab",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now().Add(-3 * time.Hour),
+	})
+	as.cloud.AddServer("ru-1", "0b7d3b4e-0000-4000-8000-000000000001", "runner-1", "a66e2ab7-3de5-4cf3-bd24-104ccb511c8c")
+	as.cloud.AddVolume("ru-1", "6f1c2d3e-0000-4000-8000-000000000001", "", "5beb9780-8eed-480f-807f-7a99c89174f2")
+
+	err := as.run("--output", "json", "cleanup", "--check-volumes", "gitlab_dev")
+
+	as.Require().NoError(err)
+	as.Assert().Equal([]string{
+		"b9551daf-10df-4739-82a0-b7efc687e9c6",
+		"a66e2ab7-3de5-4cf3-bd24-104ccb511c8c",
+		"5beb9780-8eed-480f-807f-7a99c89174f2",
+	}, as.imageIDs("ru-1"))
+	as.Assert().Contains(as.out.String(), `"reason": "in use by server runner-1"`)
+	as.Assert().Contains(as.out.String(), `"reason": "in use by volume 6f1c2d3e-0000-4000-8000-000000000001"`)
+	as.Assert().Contains(as.out.String(), `"rule": "in-use"`)
+}
+
+func (as *AppSuite) TestCleanupKeepTags() {
+	commits := as.initRepo(3)
+	repo, err := git.PlainOpen(".")
+	as.Require().NoError(err)
+	_, err = repo.CreateTag("v1.0", plumbing.NewHash(commits[2]), &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "ci", Email: "ci@example.com", When: time.Now()},
+		Message: "release",
+	})
+	as.Require().NoError(err)
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:        "b9551daf-10df-4739-82a0-b7efc687e9c6",
+		Name:      "gitlab_dev",
+		Tags:      []string{commits[0]},
+		CreatedAt: time.Now(),
+	}, images.Image{
+		ID:        "a66e2ab7-3de5-4cf3-bd24-104ccb511c8c",
+		Name:      "gitlab_dev",
+		Tags:      []string{commits[1]},
+		CreatedAt: time.Now().Add(-time.Hour),
+	}, images.Image{
+		ID:        "5beb9780-8eed-480f-807f-7a99c89174f2",
+		Name:      "gitlab_dev",
+		Tags:      []string{commits[2]},
+		CreatedAt: time.Now().Add(-2 * time.Hour),
+	})
+
+	err = as.run("cleanup", "--scandepth", "1", "--keep-tags", "v*", "gitlab_dev")
+
+	as.Require().NoError(err)
+	as.Assert().Equal([]string{
+		"b9551daf-10df-4739-82a0-b7efc687e9c6",
+		"5beb9780-8eed-480f-807f-7a99c89174f2",
+	}, as.imageIDs("ru-1"))
+}
+
+func (as *AppSuite) TestCleanupGitRepo() {
+	commits := as.initRepo(3)
+	dir, err := os.Getwd()
+	as.Require().NoError(err)
+	repo, err := git.PlainOpen(dir)
+	as.Require().NoError(err)
+	as.Require().NoError(repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("old"), plumbing.NewHash(commits[2]))))
+	as.chdir(as.T().TempDir())
+
+	for _, source := range [][]string{{"--git-repo", dir}, {"--git-url", "file://" + dir}} {
+		as.SetupTest()
+		as.cloud.AddImages("ru-1", images.Image{
+			ID:        "b9551daf-10df-4739-82a0-b7efc687e9c6",
+			Name:      "gitlab_dev",
+			Tags:      []string{commits[0]},
+			CreatedAt: time.Now(),
+		}, images.Image{
+			ID:        "a66e2ab7-3de5-4cf3-bd24-104ccb511c8c",
+			Name:      "gitlab_dev",
+			Tags:      []string{commits[1]},
+			CreatedAt: time.Now().Add(-time.Hour),
+		}, images.Image{
+			ID:        "5beb9780-8eed-480f-807f-7a99c89174f2",
+			Name:      "gitlab_dev",
+			Tags:      []string{commits[2]},
+			CreatedAt: time.Now().Add(-2 * time.Hour),
+		})
+
+		err = as.run(append([]string{"cleanup", "--scandepth", "1", "--git-ref", "old"}, append(source, "gitlab_dev")...)...)
+
+		as.Require().NoError(err, source)
+		as.Assert().Equal([]string{
+			"b9551daf-10df-4739-82a0-b7efc687e9c6",
+			"5beb9780-8eed-480f-807f-7a99c89174f2",
+		}, as.imageIDs("ru-1"), source)
+	}
+}
+
+func (as *AppSuite) TestCleanupFirstParent() {
+	// c1 - c0 - m mainline, f1 - f2 feature branch merged by m
+	dir := as.T().TempDir()
+	repo, err := git.PlainInit(dir, false)
+	as.Require().NoError(err)
+	wt, err := repo.Worktree()
+	as.Require().NoError(err)
+	commit := func(age time.Duration, parents ...plumbing.Hash) string {
+		sig := &object.Signature{Name: "ci", Email: "ci@example.com", When: time.Now().Add(-age)}
+		hash, err := wt.Commit("commit", &git.CommitOptions{
+			AllowEmptyCommits: true,
+			Author:            sig,
+			Committer:         sig,
+			Parents:           parents,
+		})
+		as.Require().NoError(err)
+		return hash.String()
+	}
+	c1 := commit(5 * time.Hour)
+	f1 := commit(4*time.Hour, plumbing.NewHash(c1))
+	f2 := commit(3*time.Hour, plumbing.NewHash(f1))
+	c0 := commit(2*time.Hour, plumbing.NewHash(c1))
+	commit(time.Hour, plumbing.NewHash(c0), plumbing.NewHash(f2))
+
+	for _, tc := range []struct {
+		args []string
+		kept []string
+	}{
+		{[]string{"--scandepth", "0"}, []string{"b9551daf-10df-4739-82a0-b7efc687e9c6"}},
+		{[]string{"--scandepth", "4"}, []string{"b9551daf-10df-4739-82a0-b7efc687e9c6", "a66e2ab7-3de5-4cf3-bd24-104ccb511c8c"}},
+		{[]string{"--scandepth", "4", "--first-parent"}, []string{"b9551daf-10df-4739-82a0-b7efc687e9c6", "5beb9780-8eed-480f-807f-7a99c89174f2"}},
+		{[]string{"--first-parent", "--until-commit", c0[:7]}, []string{"b9551daf-10df-4739-82a0-b7efc687e9c6"}},
+		{[]string{"--first-parent", "--until-commit", strings.ToUpper(c0[:7])}, []string{"b9551daf-10df-4739-82a0-b7efc687e9c6"}},
+		{[]string{"--since", "4h"}, []string{"b9551daf-10df-4739-82a0-b7efc687e9c6", "a66e2ab7-3de5-4cf3-bd24-104ccb511c8c"}},
+		{[]string{"--since", "4h", "--first-parent"}, []string{"b9551daf-10df-4739-82a0-b7efc687e9c6"}},
+	} {
+		as.SetupTest()
+		as.cloud.AddImages("ru-1", images.Image{
+			ID:        "b9551daf-10df-4739-82a0-b7efc687e9c6",
+			Name:      "gitlab_dev",
+			CreatedAt: time.Now(),
+		}, images.Image{
+			ID:        "a66e2ab7-3de5-4cf3-bd24-104ccb511c8c",
+			Name:      "gitlab_dev",
+			Tags:      []string{f2},
+			CreatedAt: time.Now().Add(-time.Hour),
+		}, images.Image{
+			ID:        "5beb9780-8eed-480f-807f-7a99c89174f2",
+			Name:      "gitlab_dev",
+			Tags:      []string{c1},
+			CreatedAt: time.Now().Add(-2 * time.Hour),
+		})
+
+		err = as.run(append(append([]string{"cleanup", "--git-repo", dir}, tc.args...), "gitlab_dev")...)
+
+		as.Require().NoError(err, tc.args)
+		as.Assert().Equal(tc.kept, as.imageIDs("ru-1"), tc.args)
+	}
+
+	err = as.run("cleanup", "--git-repo", dir, "--until-commit", "0000000", "gitlab_dev")
+
+	as.Require().ErrorContains(err, "commit 0000000 not found in history")
+
+	for _, until := range []string{c0[:1], c0[:6], "zzzzzzz"} {
+		err = as.run("cleanup", "--git-repo", dir, "--until-commit", until, "gitlab_dev")
+
+		as.Require().EqualError(err, fmt.Sprintf("until-commit: commit %q must be 7 to 40 hex digits", until))
+	}
+}
+
+func (as *AppSuite) TestCleanupGitRepoAndURL() {
+	err := as.run("cleanup", "--git-repo", ".", "--git-url", "https://example.com/repo.git", "gitlab_dev")
+
+	as.Require().EqualError(err, "--git-repo and --git-url can't be used together")
+}
+
+func (as *AppSuite) TestPublishDryRunJSON() {
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:        "e6637019-e80c-49b1-84ff-1bbe97cfcd64",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now(),
+	}, images.Image{
+		ID:         "5beb9780-8eed-480f-807f-7a99c89174f2",
+		Name:       "gitlab_dev",
+		Visibility: images.ImageVisibilityPublic,
+		CreatedAt:  time.Now().Add(-time.Hour),
+	})
+
+	err := as.run("--output", "json", "publish", "--dry-run", "e6637019-e80c-49b1-84ff-1bbe97cfcd64")
+
+	as.Require().NoError(err)
+	as.Assert().JSONEq(`{
+		"dry_run": true,
+		"published": [{"region": "ru-1", "id": "e6637019-e80c-49b1-84ff-1bbe97cfcd64", "name": "gitlab_dev"}],
+		"unpublished": [{"region": "ru-1", "id": "5beb9780-8eed-480f-807f-7a99c89174f2", "name": "gitlab_dev"}],
+		"failed_regions": []
+	}`, as.out.String())
+}
+
+func (as *AppSuite) TestPublish() {
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:         "e6637019-e80c-49b1-84ff-1bbe97cfcd64",
+		Name:       "gitlab_dev",
+		Visibility: images.ImageVisibilityPrivate,
+		CreatedAt:  time.Now(),
+	}, images.Image{
+		ID:         "5beb9780-8eed-480f-807f-7a99c89174f2",
+		Name:       "gitlab_dev",
+		Visibility: images.ImageVisibilityPublic,
+		Protected:  true,
+		CreatedAt:  time.Now().Add(-time.Hour),
+	})
+
+	err := as.run("publish", "--protected", "e6637019-e80c-49b1-84ff-1bbe97cfcd64")
+
+	as.Require().NoError(err)
+	imgs := as.cloud.Store("ru-1").Images()
+	as.Assert().Equal(images.ImageVisibilityPublic, imgs[0].Visibility)
+	as.Assert().True(imgs[0].Protected)
+	as.Assert().Equal(images.ImageVisibilityPrivate, imgs[1].Visibility)
+	as.Assert().False(imgs[1].Protected)
+}
+
+func (as *AppSuite) TestPublishRegions() {
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:         "e6637019-e80c-49b1-84ff-1bbe97cfcd64",
+		Name:       "gitlab_dev",
+		Visibility: images.ImageVisibilityPrivate,
+		CreatedAt:  time.Now(),
+	})
+	// the copy uploaded to ru-3 has its own ID
+	as.cloud.AddImages("ru-3", images.Image{
+		ID:         "0c3f2a8e-6b1d-4f3a-9d2e-7a5b8c9d0e1f",
+		Name:       "gitlab_dev",
+		Visibility: images.ImageVisibilityPrivate,
+		CreatedAt:  time.Now(),
+	}, images.Image{
+		ID:         "5beb9780-8eed-480f-807f-7a99c89174f2",
+		Name:       "gitlab_dev",
+		Visibility: images.ImageVisibilityPublic,
+		CreatedAt:  time.Now().Add(-time.Hour),
+	})
+
+	err := as.run("--output", "json", "publish", "--regions", "ru-1,ru-3", "e6637019-e80c-49b1-84ff-1bbe97cfcd64")
+
+	as.Require().NoError(err)
+	as.Assert().JSONEq(`{
+		"dry_run": false,
+		"published": [
+			{"region": "ru-1", "id": "e6637019-e80c-49b1-84ff-1bbe97cfcd64", "name": "gitlab_dev"},
+			{"region": "ru-3", "id": "0c3f2a8e-6b1d-4f3a-9d2e-7a5b8c9d0e1f", "name": "gitlab_dev"}
+		],
+		"unpublished": [{"region": "ru-3", "id": "5beb9780-8eed-480f-807f-7a99c89174f2", "name": "gitlab_dev"}],
+		"failed_regions": []
+	}`, as.out.String())
+	as.Assert().Equal(images.ImageVisibilityPublic, as.cloud.Store("ru-1").Images()[0].Visibility)
+	imgs := as.cloud.Store("ru-3").Images()
+	as.Assert().Equal(images.ImageVisibilityPublic, imgs[0].Visibility)
+	as.Assert().Equal(images.ImageVisibilityPrivate, imgs[1].Visibility)
+}
+
+func (as *AppSuite) TestPublishRegionsMissingCopy() {
+	as.cloud.AddImages("ru-1", images.Image{
+		ID:        "e6637019-e80c-49b1-84ff-1bbe97cfcd64",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now(),
+	})
+
+	err := as.run("publish", "--regions", "ru-1,ru-3", "e6637019-e80c-49b1-84ff-1bbe97cfcd64")
+
+	as.Require().EqualError(err, "failed in 1 of 2 regions: ru-3")
+	as.Assert().Equal(images.ImageVisibilityPublic, as.cloud.Store("ru-1").Images()[0].Visibility)
+	as.Assert().Contains(as.out.String(), "ru-3: failed: image e6637019-e80c-49b1-84ff-1bbe97cfcd64 not found, no image named gitlab_dev")
+}
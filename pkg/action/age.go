@@ -0,0 +1,77 @@
+package action
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hornwind/openstack-image-keeper/pkg/retention"
+)
+
+// ageValue is a urfave generic flag value for durations which also
+// accept days and weeks, e.g. 72h, 90d or 2w3d.
+type ageValue struct {
+	d *time.Duration
+}
+
+// Set parses age into destination.
+func (a ageValue) Set(s string) error {
+	d, err := retention.ParseAge(s)
+	if err != nil {
+		return err
+	}
+	*a.d = d
+
+	return nil
+}
+
+// Get returns the parsed duration, urfave reads flag values with it.
+func (a ageValue) Get() interface{} {
+	return *a.d
+}
+
+// String returns age in Go duration format.
+func (a ageValue) String() string {
+	if a.d == nil || *a.d == 0 {
+		return ""
+	}
+
+	return retention.FormatAge(*a.d)
+}
+
+// dateValue is a urfave generic flag value for points in time given as
+// RFC 3339, a date like 2023-10-01 or an age back from now like 30d.
+type dateValue struct {
+	t *time.Time
+}
+
+// Set parses date into destination.
+func (d dateValue) Set(s string) error {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			*d.t = t
+			return nil
+		}
+	}
+
+	age, err := retention.ParseAge(s)
+	if err != nil {
+		return fmt.Errorf("%q is neither RFC 3339 time, YYYY-MM-DD date nor age", s)
+	}
+	*d.t = time.Now().Add(-age)
+
+	return nil
+}
+
+// Get returns the parsed time, urfave reads flag values with it.
+func (d dateValue) Get() interface{} {
+	return *d.t
+}
+
+// String returns date in RFC 3339 format.
+func (d dateValue) String() string {
+	if d.t == nil || d.t.IsZero() {
+		return ""
+	}
+
+	return d.t.Format(time.RFC3339)
+}
@@ -3,15 +3,20 @@ package action
 import (
 	"context"
 	"fmt"
-	"os"
+	"regexp"
+	"strings"
 	"text/template"
+	"time"
 
-	"github.com/gophercloud/gophercloud"
-	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
 	gh "github.com/hornwind/openstack-image-keeper/pkg/git-history"
+	"github.com/hornwind/openstack-image-keeper/pkg/imagestore"
 	log "github.com/hornwind/openstack-image-keeper/pkg/logging"
+	"github.com/hornwind/openstack-image-keeper/pkg/retention"
+	"github.com/hornwind/openstack-image-keeper/pkg/usage"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
 )
 
@@ -19,13 +24,58 @@ var _ Action = (*CleanupByName)(nil)
 
 // CleanupByName is a struct for running 'cleanup' command.
 type CleanupByName struct {
+	conn              connector
+	history           func(scanDepth int) ([]string, error)
+	tags              func() (map[string]string, error)
+	currentBranch     func() (string, error)
+	git               gh.Options
+	repo              *gh.Repo
+	since             time.Time
+	untilCommit       string
+	firstParent       bool
+	commitTagPattern  string
+	commitProperty    string
+	commitRefs        retention.CommitFunc
+	policy            *retention.Policy
+	gitTags           map[string]string
+	regions           cli.StringSlice
+	branches          cli.StringSlice
+	keepTags          cli.StringSlice
 	savedImages       map[string]images.Image
 	imagesForDeletion map[string]images.Image
+	decisions         []retention.Decision
+	groups            []groupReport
+	names             []string
+	regex             *regexp.Regexp
+	group             groupKey
+	report            cleanupReport
 	loglevel          string
 	scanDepth         int
+	keepLast          int
+	minAge            time.Duration
+	maxAge            time.Duration
+	policyFile        string
+	nameRegex         string
+	groupBy           string
+	branch            string
+	branchAware       bool
+	keepPatches       int
+	keepMinors        int
+	versionFrom       string
+	version           retention.VersionFunc
 	dryRun            bool
+	explain           bool
+	checkInUse        bool
+	checkVolumes      bool
+	unprotect         bool
+	continueOnError   bool
+	failures          deleteFailures
+	parallel          int
 }
 
+// ruleInUse is the rule of images kept because servers or volumes use them.
+const ruleInUse = "in-use"
+
 var (
 	tplOutput = `Saved images:
 {{- range $key, $val := .savedImages }}
@@ -38,6 +88,22 @@ Images for deletion:
 {{- end }}
 {{- print "\n" }}
 `
+	tplExplain = `Saved images:
+{{- range .saved }}
+  {{ template "decision" . }}
+{{- end }}
+
+Images for deletion:
+{{- range .deleted }}
+  {{ template "decision" . }}
+{{- end }}
+{{- print "\n" }}
+{{- define "decision" }}{{ .Image.ID }}
+    Name: {{ .Image.Name }}
+    Created: {{ .Image.CreatedAt.Format "2006-01-02T15:04:05Z07:00" }}
+    Tags: {{ join .Image.Tags ", " }}
+    Reason: {{ .Reason }} (rule {{ .Rule }})
+{{- end }}`
 )
 
 // Run is the main function for 'cleanup' command.
@@ -47,213 +113,566 @@ func (c *CleanupByName) Run(ctx context.Context) error {
 		return err
 	}
 
-	c.savedImages = make(map[string]images.Image, 0)
-	c.imagesForDeletion = make(map[string]images.Image, 0)
-
-	imageName, ok := ctx.Value("firstArg").(string)
-	if !ok || imageName == "" {
-		msg := "Image name arg assertion failed"
-		err := fmt.Errorf("%s", msg)
+	if err := c.prepareNames(ctx); err != nil {
 		return err
 	}
-	listOpts := &images.ListOpts{
-		Owner: os.Getenv("OS_PROJECT_ID"),
-		Name:  imageName,
+	var err error
+	c.group, err = parseGroupBy(c.groupBy)
+	if err != nil {
+		return err
+	}
+	if c.keepLast < 0 {
+		return fmt.Errorf("keep-last must not be negative, got %d", c.keepLast)
+	}
+	if c.parallel < 0 {
+		return fmt.Errorf("parallel must not be negative, got %d", c.parallel)
+	}
+	if c.keepPatches > 0 {
+		if c.keepMinors < 1 {
+			return fmt.Errorf("keep-minors must be positive, got %d", c.keepMinors)
+		}
+		c.version, err = retention.VersionFrom(c.versionFrom)
+		if err != nil {
+			return err
+		}
+	}
+	if c.git.Path != "" && c.git.URL != "" {
+		return fmt.Errorf("--git-repo and --git-url can't be used together")
+	}
+	if c.untilCommit != "" {
+		if c.untilCommit, err = gh.ParseCommit(c.untilCommit); err != nil {
+			return fmt.Errorf("until-commit: %w", err)
+		}
+	}
+	c.commitRefs = nil
+	if c.commitTagPattern != "" || c.commitProperty != "" {
+		c.commitRefs, err = retention.CommitsFrom(c.commitTagPattern, c.commitProperty)
+		if err != nil {
+			return err
+		}
 	}
 
-	client, err := c.getImageServiceClient()
+	c.conn, err = connect(ctx, c.conn)
 	if err != nil {
 		return err
 	}
+	if c.history == nil {
+		c.history = func(scanDepth int) ([]string, error) {
+			repo, err := c.gitRepo()
+			if err != nil {
+				return nil, err
+			}
+			return repo.Scan(c.window(scanDepth))
+		}
+	}
+	if c.tags == nil {
+		c.tags = func() (map[string]string, error) {
+			repo, err := c.gitRepo()
+			if err != nil {
+				return nil, err
+			}
+			return repo.TagCommits()
+		}
+	}
+	if c.currentBranch == nil {
+		c.currentBranch = func() (string, error) {
+			repo, err := c.gitRepo()
+			if err != nil {
+				return "", err
+			}
+			return repo.CurrentBranch()
+		}
+	}
+	c.policy, err = c.retentionPolicy()
+	if err != nil {
+		return err
+	}
+	if err := c.resolveBranch(); err != nil {
+		return err
+	}
 
 	log.Infof("Dry-run %t", c.dryRun)
-	if err = c.buildLists(imageName, client, listOpts); err != nil {
+	c.report = cleanupReport{
+		NameRegex: c.nameRegex,
+		DryRun:    c.dryRun,
+		Groups:    []groupReport{},
+		Kept:      []decisionReport{},
+		Deleted:   []decisionReport{},
+		Failed:    []deleteFailure{},
+	}
+	c.failures = nil
+	if len(c.names) == 1 {
+		c.report.Name = c.names[0]
+	}
+	c.report.FailedRegions, err = forEachRegion(ctx, c.conn, c.regions.Value(), c.cleanupRegion)
+	if structured(ctx) {
+		c.report.Failed = append(c.report.Failed, c.failures...)
+		if werr := writeDocument(ctx, c.report); werr != nil {
+			return werr
+		}
+	} else {
+		c.failures.print(outputWriter(ctx))
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.failures.err()
+}
+
+// prepareNames reads image names from arguments and compiles --name-regex.
+func (c *CleanupByName) prepareNames(ctx context.Context) error {
+	c.names = []string{}
+	args, _ := ctx.Value("allArgs").([]string)
+	for _, name := range args {
+		if name != "" && !slices.Contains(c.names, name) {
+			c.names = append(c.names, name)
+		}
+	}
+
+	switch {
+	case len(c.names) > 0 && c.nameRegex != "":
+		return fmt.Errorf("image names and --name-regex can't be used together")
+	case len(c.names) == 0 && c.nameRegex == "" && (c.groupBy == "" || c.groupBy == groupByName):
+		return fmt.Errorf("image name or --name-regex is required")
+	case c.nameRegex != "":
+		re, err := regexp.Compile(c.nameRegex)
+		if err != nil {
+			return fmt.Errorf("name-regex: %w", err)
+		}
+		c.regex = re
+	}
+
+	return nil
+}
+
+func (c *CleanupByName) cleanupRegion(ctx context.Context, store imagestore.ImageStore) error {
+	log := log.GetLogger()
+
+	c.savedImages = make(map[string]images.Image, 0)
+	c.imagesForDeletion = make(map[string]images.Image, 0)
+	c.decisions = nil
+	c.groups = nil
+
+	if err := c.buildLists(ctx, store); err != nil {
 		return err
 	}
 
+	switch {
+	case structured(ctx):
+		c.reportDecisions(ctx)
+	case c.explain:
+		c.explainDecisions(ctx)
+		c.printGroups(ctx)
+	default:
+		val := make(map[string]interface{}, 6)
+		val["savedImages"] = c.savedImages
+		val["imagesForDeletion"] = c.imagesForDeletion
+		template.Must(template.New("Output").Parse(tplOutput)).Execute(outputWriter(ctx), val) //nolint:errcheck
+		c.printGroups(ctx)
+	}
+
 	if !c.dryRun {
-		log.Infof("Running cleanup for %s", imageName)
-		return c.cleanupImages(ctx, client)
+		log.Infof("Running cleanup for %s", c.target())
+		return c.cleanupImages(ctx, store)
 	}
 
 	return nil
 }
 
-func (c *CleanupByName) getImageServiceClient() (*gophercloud.ServiceClient, error) {
-	ao, err := openstack.AuthOptionsFromEnv()
-	if err != nil {
-		return nil, err
+// target describes the cleaned images for logs.
+func (c *CleanupByName) target() string {
+	switch {
+	case c.regex != nil:
+		return "names matching " + c.nameRegex
+	case len(c.names) == 0:
+		return "images grouped by " + c.groupBy
+	default:
+		return strings.Join(c.names, ", ")
 	}
-	authOpts := &ao
-	provider, err := openstack.AuthenticatedClient(*authOpts)
-	if err != nil {
-		return nil, err
+}
+
+// listImages returns images with the requested names, names matching
+// --name-regex, or all images if neither is set.
+func (c *CleanupByName) listImages(ctx context.Context, store imagestore.ImageStore) ([]images.Image, error) {
+	if len(c.names) == 0 {
+		imgs, err := store.List(ctx, images.ListOpts{})
+		if err != nil {
+			return nil, err
+		}
+		output := []images.Image{}
+		for _, i := range imgs {
+			if c.regex == nil || c.regex.MatchString(i.Name) {
+				output = append(output, i)
+			}
+		}
+		return output, nil
 	}
-	eo := &gophercloud.EndpointOpts{
-		Region: os.Getenv("OS_REGION_NAME"),
+
+	output := []images.Image{}
+	for _, name := range c.names {
+		imgs, err := store.List(ctx, images.ListOpts{Name: name})
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, imgs...)
 	}
 
-	return openstack.NewImageServiceV2(provider, *eo)
+	return output, nil
 }
 
-func (c *CleanupByName) buildLists(name string, client *gophercloud.ServiceClient, listOpts *images.ListOpts) error {
+func (c *CleanupByName) buildLists(ctx context.Context, store imagestore.ImageStore) error {
 	log := log.GetLogger()
 
-	allPages, err := images.List(client, *listOpts).AllPages()
+	imgs, err := c.listImages(ctx, store)
 	if err != nil {
 		log.Error(err)
 		return err
 	}
 
-	imgs, _ := images.ExtractImages(allPages)
 	if len(imgs) < 1 {
 		return nil
 	}
 
-	commits, err := gh.GetNCommitsFromHead(c.scanDepth)
+	commits, err := c.history(c.scanDepth)
 	if err != nil {
 		return err
 	}
 
-	return c.filterImagesByCommitAndTime(imgs, commits)
+	if c.policy != nil && c.policy.Has(retention.RuleGitTag) {
+		c.gitTags, err = c.tags()
+		if err != nil {
+			return err
+		}
+	}
+
+	var checker usage.Checker
+	if c.checkInUse {
+		checker, err = c.conn.Usage(currentRegion(ctx), c.checkVolumes)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, g := range groupImages(imgs, c.group) {
+		kept, deleted, decided := len(c.savedImages), len(c.imagesForDeletion), len(c.decisions)
+		if err := c.filterImagesByCommitAndTime(g.images, commits); err != nil {
+			return err
+		}
+		c.keepProtected(c.decisions[decided:])
+		if checker != nil {
+			if err := c.keepInUse(ctx, checker, c.decisions[decided:]); err != nil {
+				return err
+			}
+		}
+		c.groups = append(c.groups, groupReport{
+			Region:  currentRegion(ctx),
+			Name:    g.name,
+			Kept:    len(c.savedImages) - kept,
+			Deleted: len(c.imagesForDeletion) - deleted,
+		})
+	}
+
+	return nil
 }
 
 func (c *CleanupByName) filterImagesByCommitAndTime(imgs []images.Image, commits []string) error {
 	log := log.GetLogger()
-	latestImg := images.Image{}
-	currentCommitImg := images.Image{}
 
-	for step, i := range imgs {
-		if i.Visibility == "public" {
-			c.savedImages[i.ID] = i
+	policy := c.policy
+	if policy == nil {
+		policy = retention.NewDefaultPolicy(c.defaultPolicyOptions())
+	}
+
+	set := &retention.Set{
+		Images:     imgs,
+		Commits:    commits,
+		GitTags:    c.gitTags,
+		Branch:     c.branch,
+		CommitRefs: c.commitRefs,
+		Now:        time.Now(),
+	}
+	decisions := policy.Evaluate(set)
+	c.decisions = append(c.decisions, decisions...)
+	for _, d := range decisions {
+		log.Debugf("%s image %s by rule %s: %s", d.Decision(), d.Image.ID, d.Rule, d.Reason)
+		if d.Keep {
+			c.savedImages[d.Image.ID] = d.Image
 			continue
 		}
-		if latestImg.ID == "" {
-			log.Debugf("latest image on step %d is %s", step, i.ID)
-			c.savedImages[i.ID] = i
-			latestImg = i
+		c.imagesForDeletion[d.Image.ID] = d.Image
+	}
+
+	return nil
+}
+
+// keepProtected keeps protected images of decisions without --unprotect,
+// a policy file may delete them by rules listed before protected.
+func (c *CleanupByName) keepProtected(decisions []retention.Decision) {
+	log := log.GetLogger()
+	if c.unprotect {
+		return
+	}
+
+	for idx := range decisions {
+		d := &decisions[idx]
+		if d.Keep || !d.Image.Protected {
+			continue
 		}
 
-		// latest for current commit
-		for _, tag := range i.Tags {
-
-			if slices.Contains(commits, tag) {
-				if currentCommitImg.ID == "" {
-					log.Debugf("latest image on step %d is %s", step, i.ID)
-					currentCommitImg = i
-					c.savedImages[i.ID] = i
-					continue
-				}
-
-				if i.CreatedAt.After(currentCommitImg.CreatedAt) {
-					log.Debugln(i.ID, "after", currentCommitImg.ID)
-					delete(c.savedImages, currentCommitImg.ID)
-					c.imagesForDeletion[currentCommitImg.ID] = currentCommitImg
-					c.savedImages[i.ID] = i
-					currentCommitImg = i
-					log.Debugf("latest image on step %d is %s", step, i.ID)
-					continue
-				}
-				if i.CreatedAt.Before(currentCommitImg.CreatedAt) {
-					log.Debugln(i.ID, "before", currentCommitImg.ID)
-					delete(c.savedImages, i.ID)
-					c.imagesForDeletion[i.ID] = i
-					log.Debugf("latest image on step %d is %s", step, currentCommitImg.ID)
-					continue
-				}
-
-				if i.CreatedAt == currentCommitImg.CreatedAt {
-					tagCommitIdx := slices.Index(commits, tag)
-
-					for _, t := range currentCommitImg.Tags {
-						currentCommitImgCommitTagIdx := slices.Index(commits, t)
-						if currentCommitImgCommitTagIdx == -1 {
-							continue
-						}
-
-						if tagCommitIdx < currentCommitImgCommitTagIdx {
-							log.Debugln(i.ID, "after", currentCommitImg.ID)
-							delete(c.savedImages, currentCommitImg.ID)
-							c.imagesForDeletion[currentCommitImg.ID] = currentCommitImg
-							c.savedImages[i.ID] = i
-							currentCommitImg = i
-							log.Debugf("latest image on step %d is %s", step, i.ID)
-							continue
-						}
-						if tagCommitIdx > currentCommitImgCommitTagIdx {
-							log.Debugln(i.ID, "before", currentCommitImg.ID)
-							delete(c.savedImages, i.ID)
-							c.imagesForDeletion[i.ID] = i
-							log.Debugf("latest image on step %d is %s", step, currentCommitImg.ID)
-							continue
-						}
-						if tagCommitIdx == currentCommitImgCommitTagIdx {
-							c.savedImages[i.ID] = i
-							continue
-						}
-					}
-				}
-			}
+		log.Warnf("Image %s is protected, keep it, use --unprotect to delete it", d.Image.ID)
+		d.Keep, d.Rule, d.Reason = true, retention.RuleProtected, "protected, use --unprotect to delete it"
+		delete(c.imagesForDeletion, d.Image.ID)
+		c.savedImages[d.Image.ID] = d.Image
+	}
+}
+
+// keepInUse keeps images of decisions used by servers or volumes whatever
+// the policy decided.
+func (c *CleanupByName) keepInUse(ctx context.Context, checker usage.Checker, decisions []retention.Decision) error {
+	log := log.GetLogger()
+
+	for idx := range decisions {
+		d := &decisions[idx]
+		if d.Keep {
+			continue
+		}
+		user, err := checker.InUse(ctx, d.Image.ID)
+		if err != nil {
+			return fmt.Errorf("check usage of image %s: %w", d.Image.ID, err)
 		}
+		if user == "" {
+			continue
+		}
+
+		log.Infof("Image %s is in use by %s, keep it", d.Image.ID, user)
+		d.Keep, d.Rule, d.Reason = true, ruleInUse, "in use by "+user
+		delete(c.imagesForDeletion, d.Image.ID)
+		c.savedImages[d.Image.ID] = d.Image
+	}
+
+	return nil
+}
+
+// printGroups prints kept and deleted counts of every group when there are several.
+func (c *CleanupByName) printGroups(ctx context.Context) {
+	if len(c.groups) < 2 {
+		return
+	}
+
+	out := outputWriter(ctx)
+	kept, deleted := 0, 0
+	fmt.Fprintln(out, "Groups:")
+	for _, g := range c.groups {
+		fmt.Fprintf(out, "  %s: kept %d, deleted %d\n", g.Name, g.Kept, g.Deleted)
+		kept += g.Kept
+		deleted += g.Deleted
+	}
+	fmt.Fprintf(out, "Total: kept %d, deleted %d\n", kept, deleted)
+}
 
-		if i.CreatedAt.After(latestImg.CreatedAt) {
-			log.Debugln(i.ID, "after", latestImg.ID)
-			delete(c.savedImages, latestImg.ID)
-			c.imagesForDeletion[latestImg.ID] = latestImg
-			c.savedImages[i.ID] = i
-			latestImg = i
-			log.Debugf("latest image on step %d is %s", step, i.ID)
+// reportDecisions adds decisions of the region to the cleanup document.
+func (c *CleanupByName) reportDecisions(ctx context.Context) {
+	c.report.Groups = append(c.report.Groups, c.groups...)
+	for _, d := range c.decisions {
+		if d.Keep {
+			c.report.Kept = append(c.report.Kept, newDecisionReport(currentRegion(ctx), d))
 			continue
 		}
-		if i.CreatedAt.Before(latestImg.CreatedAt) {
-			log.Debugln(i.ID, "before", latestImg.ID)
-			delete(c.savedImages, i.ID)
-			c.imagesForDeletion[i.ID] = i
-			log.Debugf("latest image on step %d is %s", step, latestImg.ID)
+		c.report.Deleted = append(c.report.Deleted, newDecisionReport(currentRegion(ctx), d))
+	}
+}
+
+// explainDecisions prints every image with the rule which kept or deleted it, newest first.
+func (c *CleanupByName) explainDecisions(ctx context.Context) {
+	saved, deleted := []retention.Decision{}, []retention.Decision{}
+	for _, d := range c.decisions {
+		if d.Keep {
+			saved = append(saved, d)
 			continue
 		}
-		if i.CreatedAt == latestImg.CreatedAt {
-			latestImg = i
-			c.savedImages[i.ID] = i
+		deleted = append(deleted, d)
+	}
+
+	val := make(map[string]interface{}, 2)
+	val["saved"] = saved
+	val["deleted"] = deleted
+	template.Must(template.New("Explain").Funcs(template.FuncMap{
+		"join": strings.Join,
+	}).Parse(tplExplain)).Execute(outputWriter(ctx), val) //nolint:errcheck
+}
+
+// window returns commits to scan, --since and --until-commit replace scanDepth.
+func (c *CleanupByName) window(scanDepth int) gh.Window {
+	w := gh.Window{
+		Depth:       scanDepth,
+		FirstParent: c.firstParent,
+		Since:       c.since,
+		Until:       c.untilCommit,
+	}
+	if !c.since.IsZero() || c.untilCommit != "" {
+		w.Depth = gh.Unlimited
+	}
+
+	return w
+}
+
+// gitRepo opens the repository selected by git flags, a remote one is cloned
+// once for all regions.
+func (c *CleanupByName) gitRepo() (*gh.Repo, error) {
+	if c.repo == nil {
+		repo, err := gh.Open(c.git)
+		if err != nil {
+			return nil, err
 		}
+		c.repo = repo
 	}
 
-	val := make(map[string]interface{}, 6)
-	val["savedImages"] = c.savedImages
-	val["imagesForDeletion"] = c.imagesForDeletion
-	template.Must(template.New("Output").Parse(tplOutput)).Execute(os.Stdout, val) //nolint:errcheck
+	return c.repo, nil
+}
+
+// resolveBranch finds the current git branch for branch rule unless --branch is set.
+func (c *CleanupByName) resolveBranch() error {
+	if c.branch != "" || !c.policy.Has(retention.RuleBranch) {
+		return nil
+	}
+
+	branch, err := c.currentBranch()
+	if err != nil {
+		return fmt.Errorf("current branch: %w", err)
+	}
+	if branch == plumbing.HEAD.String() {
+		return fmt.Errorf("HEAD is detached, set the branch with --branch")
+	}
+	c.branch = branch
 
 	return nil
 }
 
-func (c *CleanupByName) cleanupImages(ctx context.Context, client *gophercloud.ServiceClient) error {
+// retentionPolicy returns policy from --policy file or the default one configured by flags.
+func (c *CleanupByName) retentionPolicy() (*retention.Policy, error) {
+	if c.policyFile != "" {
+		policy, err := retention.Load(c.policyFile)
+		if err != nil {
+			return nil, err
+		}
+		if !c.unprotect && !policy.Has(retention.RuleProtected) {
+			// Glance refuses to delete protected images
+			policy.Rules = append([]retention.Rule{retention.Protected()}, policy.Rules...)
+		}
+		return policy, nil
+	}
+
+	return retention.NewDefaultPolicy(c.defaultPolicyOptions()), nil
+}
+
+func (c *CleanupByName) defaultPolicyOptions() retention.DefaultOptions {
+	return retention.DefaultOptions{
+		KeepLast: c.keepLast,
+		MinAge:   c.minAge,
+		MaxAge:   c.maxAge,
+
+		BranchAware: c.branchAware || len(c.branches.Value()) > 0,
+		Branches:    c.branches.Value(),
+		KeepTags:    c.keepTags.Value(),
+		KeepPatches: c.keepPatches,
+		KeepMinors:  c.keepMinors,
+		Version:     c.version,
+		Unprotect:   c.unprotect,
+	}
+}
+
+// cleanupImages deletes --parallel images at once, results are reported in
+// order of image ID.
+func (c *CleanupByName) cleanupImages(ctx context.Context, store imagestore.ImageStore) error {
 	log := log.GetLogger()
-	for _, img := range c.imagesForDeletion {
-		result := images.Delete(client, img.ID)
-		log.Infof("Delete image %s", img.ID)
-		if result.Err != nil {
-			return result.Err
+
+	ids := maps.Keys(c.imagesForDeletion)
+	slices.Sort(ids)
+	imgs := make([]images.Image, 0, len(ids))
+	for _, id := range ids {
+		imgs = append(imgs, c.imagesForDeletion[id])
+	}
+
+	errs := parallel(ctx, c.parallel, len(imgs), !c.continueOnError, func(ctx context.Context, idx int) error {
+		return deleteImage(ctx, store, imgs[idx])
+	})
+	for idx, err := range errs {
+		img := imgs[idx]
+		switch {
+		case err == nil:
+			log.Infof("Deleted image %s", img.ID)
+		case err == errNotStarted:
+		case !c.continueOnError:
+			return err
+		default:
+			log.Errorf("Delete image %s: %s", img.ID, err)
+			c.failures.add(currentRegion(ctx), img.ID, err)
 		}
 	}
 
 	return nil
 }
 
+// deleteImage deletes img, protection of protected images is cleared first.
+func deleteImage(ctx context.Context, store imagestore.ImageStore, img images.Image) error {
+	if img.Protected {
+		if _, err := store.Update(ctx, img.ID, images.UpdateOpts{
+			images.ReplaceImageProtected{NewProtected: false},
+		}); err != nil {
+			return fmt.Errorf("unprotect: %w", err)
+		}
+	}
+
+	return store.Delete(ctx, img.ID)
+}
+
 // Cmd returns 'cleanup' *cli.Command.
 func (c *CleanupByName) Cmd() *cli.Command {
 	return &cli.Command{
-		Name:   "cleanup",
-		Usage:  "Cleanup images by name",
-		Flags:  c.flags(),
-		Action: toCtx(c.Run),
+		Name:      "cleanup",
+		Usage:     "Cleanup images by name",
+		ArgsUsage: "<name>...",
+		Flags:     c.flags(),
+		Action:    toCtx(c.Run),
 	}
 }
 
 // flags return flag set of CLI urfave.
 func (c *CleanupByName) flags() []cli.Flag {
 	self := []cli.Flag{
+		flagNameRegex(&c.nameRegex),
+		flagGroupBy(&c.groupBy),
+		flagGitRepo(&c.git.Path),
+		flagGitURL(&c.git.URL),
+		flagGitRef(&c.git.Ref),
+		flagGitToken(&c.git.Token),
+		flagGitSSHKey(&c.git.SSHKey),
 		flagScanDepth(&c.scanDepth),
+		flagFirstParent(&c.firstParent),
+		flagSince(&c.since),
+		flagUntilCommit(&c.untilCommit),
+		flagCommitTagPattern(&c.commitTagPattern),
+		flagCommitProperty(&c.commitProperty),
+		flagKeepLast(&c.keepLast),
+		flagMinAge(&c.minAge),
+		flagMaxAge(&c.maxAge),
+		flagPolicy(&c.policyFile),
+		flagBranchAware(&c.branchAware),
+		flagBranch(&c.branch),
+		flagBranches(&c.branches),
+		flagKeepTags(&c.keepTags),
+		flagKeepPatches(&c.keepPatches),
+		flagKeepMinors(&c.keepMinors),
+		flagVersionFrom(&c.versionFrom),
+		flagRegions(&c.regions),
+		flagUnprotect(&c.unprotect),
+		flagCheckInUse(&c.checkInUse),
+		flagCheckVolumes(&c.checkVolumes),
+		flagParallel(&c.parallel),
+		flagContinueOnError(&c.continueOnError),
 		flagDryRun(&c.dryRun),
+		flagExplain(&c.explain),
 		flagLogLevel(&c.loglevel),
 	}
 
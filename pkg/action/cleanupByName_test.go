@@ -1,10 +1,19 @@
 package action
 
 import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/hornwind/openstack-image-keeper/pkg/imagestore"
+	"github.com/hornwind/openstack-image-keeper/pkg/retention"
+	"github.com/hornwind/openstack-image-keeper/pkg/usage"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -117,3 +126,655 @@ func (ifs *ImageFilterSuite) TestFilterByCommitInSameTime() {
 	ifs.Assert().Contains(ifs.cleanup.imagesForDeletion, images[2].ID)
 	ifs.Assert().NotContains(ifs.cleanup.imagesForDeletion, images[1].ID)
 }
+
+func (ifs *ImageFilterSuite) TestFilterKeepLast() {
+	ifs.cleanup.keepLast = 3
+	images := []images.Image{{
+		ID:         "2f7d0c52-6d53-4d4b-8a53-0e2f3b5f0a01",
+		Tags:       []string{"master"},
+		Visibility: "private",
+		CreatedAt:  time.Now().Add(-time.Hour * 2),
+	}, {
+		ID:         "61f3b1cc-1f7e-4fd8-a3ee-cc1e3d2f0a02",
+		Tags:       []string{"master"},
+		Visibility: "private",
+		CreatedAt:  time.Now(),
+	}, {
+		ID:         "9a4c2b0e-3d1f-4b6a-8e7c-5d2a1f0b0a03",
+		Tags:       []string{},
+		Visibility: "public",
+		CreatedAt:  time.Now().Add(-time.Hour * 1),
+	}, {
+		ID:         "c8e1d3f2-7b6a-4c5d-9e8f-1a2b3c4d0a04",
+		Tags:       []string{"master"},
+		Visibility: "private",
+		CreatedAt:  time.Now().Add(-time.Hour * 3),
+	}, {
+		ID:         "d4b3a2c1-0f9e-4d8c-7b6a-5e4d3c2b0a05",
+		Tags:       []string{"master"},
+		Visibility: "private",
+		CreatedAt:  time.Now().Add(-time.Hour * 4),
+	}}
+	err := ifs.cleanup.filterImagesByCommitAndTime(images, ifs.commitList)
+
+	ifs.Assert().Nil(err)
+	for _, i := range images[:4] {
+		ifs.Assert().Contains(ifs.cleanup.savedImages, i.ID)
+		ifs.Assert().NotContains(ifs.cleanup.imagesForDeletion, i.ID)
+	}
+	ifs.Assert().Contains(ifs.cleanup.imagesForDeletion, images[4].ID)
+	ifs.Assert().NotContains(ifs.cleanup.savedImages, images[4].ID)
+}
+
+func (ifs *ImageFilterSuite) TestFilterMaxAge() {
+	ifs.cleanup.keepLast = 1
+	ifs.cleanup.maxAge = 90 * 24 * time.Hour
+	images := []images.Image{{
+		ID:         "0b6f3c2a-8a51-4a7e-9f0e-5c2d1b3a4e01",
+		Tags:       []string{"master"},
+		Visibility: "private",
+		CreatedAt:  time.Now().Add(-time.Hour * 24 * 120),
+	}, {
+		ID:         "1c7a4d3b-9b62-4b8f-a01f-6d3e2c4b5f02",
+		Tags:       []string{ifs.commitList[0], "master"},
+		Visibility: "private",
+		CreatedAt:  time.Now().Add(-time.Hour * 24 * 100),
+	}, {
+		ID:         "2d8b5e4c-ac73-4c90-b12a-7e4f3d5c6a03",
+		Tags:       []string{},
+		Visibility: "public",
+		CreatedAt:  time.Now().Add(-time.Hour * 24 * 200),
+	}}
+	err := ifs.cleanup.filterImagesByCommitAndTime(images, ifs.commitList)
+
+	ifs.Assert().Nil(err)
+	ifs.Assert().Contains(ifs.cleanup.savedImages, images[1].ID, "kept by --keep-last")
+	ifs.Assert().Contains(ifs.cleanup.imagesForDeletion, images[0].ID)
+	ifs.Assert().Contains(ifs.cleanup.savedImages, images[2].ID)
+	ifs.Assert().NotContains(ifs.cleanup.imagesForDeletion, images[2].ID)
+}
+
+func (ifs *ImageFilterSuite) TestFilterMaxAgeCommit() {
+	ifs.cleanup.keepLast = 1
+	ifs.cleanup.maxAge = 90 * 24 * time.Hour
+	images := []images.Image{{
+		ID:         "3e9c6f5d-bd84-4da1-823b-8f5a4e6d7b04",
+		Tags:       []string{"master"},
+		Visibility: "private",
+		CreatedAt:  time.Now(),
+	}, {
+		ID:         "4fad7a6e-ce95-4eb2-934c-9a6b5f7e8c05",
+		Tags:       []string{ifs.commitList[0], "master"},
+		Visibility: "private",
+		CreatedAt:  time.Now().Add(-time.Hour * 24 * 100),
+	}}
+	err := ifs.cleanup.filterImagesByCommitAndTime(images, ifs.commitList)
+
+	ifs.Assert().Nil(err)
+	ifs.Assert().Contains(ifs.cleanup.savedImages, images[0].ID)
+	ifs.Assert().Contains(ifs.cleanup.imagesForDeletion, images[1].ID)
+	ifs.Assert().NotContains(ifs.cleanup.savedImages, images[1].ID)
+}
+
+func (ifs *ImageFilterSuite) TestFilterMinAge() {
+	ifs.cleanup.minAge = 72 * time.Hour
+	images := []images.Image{{
+		ID:         "5abe8b7f-dfa6-4fc3-a45d-ab7c6a8f9d06",
+		Tags:       []string{"master"},
+		Visibility: "private",
+		CreatedAt:  time.Now(),
+	}, {
+		ID:         "6bcf9c8a-e0b7-40d4-b56e-bc8d7b9a0e07",
+		Tags:       []string{"master"},
+		Visibility: "private",
+		CreatedAt:  time.Now().Add(-time.Hour * 1),
+	}, {
+		ID:         "7cd0ad9b-f1c8-41e5-867f-cd9e8cab1f08",
+		Tags:       []string{"master"},
+		Visibility: "private",
+		CreatedAt:  time.Now().Add(-time.Hour * 100),
+	}}
+	err := ifs.cleanup.filterImagesByCommitAndTime(images, ifs.commitList)
+
+	ifs.Assert().Nil(err)
+	ifs.Assert().Contains(ifs.cleanup.savedImages, images[0].ID)
+	ifs.Assert().Contains(ifs.cleanup.savedImages, images[1].ID)
+	ifs.Assert().NotContains(ifs.cleanup.imagesForDeletion, images[1].ID)
+	ifs.Assert().Contains(ifs.cleanup.imagesForDeletion, images[2].ID)
+}
+
+type CleanupSuite struct {
+	suite.Suite
+	cleanup    *CleanupByName
+	store      *imagestore.Memory
+	out        *bytes.Buffer
+	commitList []string
+}
+
+func TestCleanup(t *testing.T) {
+	suite.Run(t, &CleanupSuite{})
+}
+
+func (cs *CleanupSuite) SetupTest() {
+	cs.commitList = []string{
+		"ad6fed9464ef6f47b2d89ab856090d25c898d259",
+		"f8b453a8b9dd6fd431577a47ec48f4ecf1500689",
+	}
+	cs.store = imagestore.NewMemory(images.Image{
+		ID:         "b9551daf-10df-4739-82a0-b7efc687e9c6",
+		Name:       "gitlab_dev",
+		Tags:       []string{cs.commitList[0], "master"},
+		Visibility: images.ImageVisibilityPrivate,
+		CreatedAt:  time.Now(),
+	}, images.Image{
+		ID:         "a66e2ab7-3de5-4cf3-bd24-104ccb511c8c",
+		Name:       "gitlab_dev",
+		Tags:       []string{cs.commitList[1], "master"},
+		Visibility: images.ImageVisibilityPrivate,
+		CreatedAt:  time.Now().Add(-time.Hour * 1),
+	}, images.Image{
+		ID:         "5beb9780-8eed-480f-807f-7a99c89174f2",
+		Name:       "gitlab_dev",
+		Visibility: images.ImageVisibilityPublic,
+		CreatedAt:  time.Now().Add(-time.Hour * 2),
+	}, images.Image{
+		ID:         "04f24cb4-beb0-4d87-b67a-d4834fba08ab",
+		Name:       "runner",
+		Visibility: images.ImageVisibilityPrivate,
+		CreatedAt:  time.Now().Add(-time.Hour * 3),
+	})
+	cs.out = &bytes.Buffer{}
+	cs.cleanup = &CleanupByName{
+		conn: memoryConnector{"": cs.store},
+		history: func(int) ([]string, error) {
+			return cs.commitList, nil
+		},
+		loglevel:  "error",
+		scanDepth: 10,
+	}
+}
+
+func (cs *CleanupSuite) imageIDs() []string {
+	ids := []string{}
+	for _, i := range cs.store.Images() {
+		ids = append(ids, i.ID)
+	}
+	return ids
+}
+
+func (cs *CleanupSuite) TestRun() {
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().NoError(err)
+	cs.Assert().Equal([]string{
+		"b9551daf-10df-4739-82a0-b7efc687e9c6",
+		"5beb9780-8eed-480f-807f-7a99c89174f2",
+		"04f24cb4-beb0-4d87-b67a-d4834fba08ab",
+	}, cs.imageIDs())
+	cs.Assert().Contains(cs.out.String(), "Images for deletion:\n  a66e2ab7-3de5-4cf3-bd24-104ccb511c8c\n")
+}
+
+func (cs *CleanupSuite) TestRunDryRun() {
+	cs.cleanup.dryRun = true
+
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().NoError(err)
+	cs.Assert().Len(cs.imageIDs(), 4)
+	cs.Assert().Contains(cs.out.String(), "Images for deletion:\n  a66e2ab7-3de5-4cf3-bd24-104ccb511c8c\n")
+}
+
+func (cs *CleanupSuite) TestRunExplain() {
+	cs.cleanup.dryRun = true
+	cs.cleanup.explain = true
+
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().NoError(err)
+	cs.Assert().Contains(cs.out.String(), "Saved images:\n  b9551daf-10df-4739-82a0-b7efc687e9c6\n    Name: gitlab_dev\n")
+	cs.Assert().Contains(cs.out.String(), "    Tags: "+cs.commitList[0]+", master\n    Reason: newest (rule latest)\n")
+	cs.Assert().Contains(cs.out.String(), "  5beb9780-8eed-480f-807f-7a99c89174f2\n")
+	cs.Assert().Contains(cs.out.String(), "    Reason: public (rule public)\n")
+	cs.Assert().Contains(cs.out.String(), "Images for deletion:\n  a66e2ab7-3de5-4cf3-bd24-104ccb511c8c\n")
+	cs.Assert().Contains(cs.out.String(), "    Reason: superseded by b9551daf-10df-4739-82a0-b7efc687e9c6 (rule default)\n")
+}
+
+func (cs *CleanupSuite) TestRunPolicy() {
+	cs.cleanup.policyFile = filepath.Join(cs.T().TempDir(), "policy.yaml")
+	cs.Require().NoError(os.WriteFile(cs.cleanup.policyFile, []byte(`
+rules:
+  - type: git-tag
+  - type: public
+default: delete
+`), 0o600))
+	cs.cleanup.tags = func() (map[string]string, error) {
+		return map[string]string{"master": "ad6fed9464ef6f47b2d89ab856090d25c898d259"}, nil
+	}
+
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().NoError(err)
+	cs.Assert().Equal([]string{
+		"b9551daf-10df-4739-82a0-b7efc687e9c6",
+		"a66e2ab7-3de5-4cf3-bd24-104ccb511c8c",
+		"5beb9780-8eed-480f-807f-7a99c89174f2",
+		"04f24cb4-beb0-4d87-b67a-d4834fba08ab",
+	}, cs.imageIDs())
+}
+
+func (cs *CleanupSuite) TestRunPolicyDelete() {
+	cs.cleanup.policyFile = filepath.Join(cs.T().TempDir(), "policy.yaml")
+	cs.Require().NoError(os.WriteFile(cs.cleanup.policyFile, []byte(`
+rules:
+  - type: public
+  - type: age
+    max: 30m
+default: keep
+`), 0o600))
+
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().NoError(err)
+	cs.Assert().Equal([]string{
+		"b9551daf-10df-4739-82a0-b7efc687e9c6",
+		"5beb9780-8eed-480f-807f-7a99c89174f2",
+		"04f24cb4-beb0-4d87-b67a-d4834fba08ab",
+	}, cs.imageIDs())
+}
+
+func (cs *CleanupSuite) addRunners() {
+	cs.store.Add(images.Image{
+		ID:         "7f3a1c55-2d4e-4b8f-9a6c-1e2d3f4a5b66",
+		Name:       "runner",
+		Visibility: images.ImageVisibilityPrivate,
+		CreatedAt:  time.Now().Add(-time.Hour * 4),
+	}, images.Image{
+		ID:         "0c9e8d7f-6a5b-4c3d-2e1f-0a9b8c7d6e55",
+		Name:       "runner_arm",
+		Visibility: images.ImageVisibilityPrivate,
+		CreatedAt:  time.Now(),
+	})
+}
+
+func (cs *CleanupSuite) TestRunNames() {
+	cs.addRunners()
+
+	err := cs.cleanup.Run(testContext(cs.out, "runner", "gitlab_dev"))
+
+	cs.Require().NoError(err)
+	cs.Assert().Equal([]string{
+		"b9551daf-10df-4739-82a0-b7efc687e9c6",
+		"5beb9780-8eed-480f-807f-7a99c89174f2",
+		"04f24cb4-beb0-4d87-b67a-d4834fba08ab",
+		"0c9e8d7f-6a5b-4c3d-2e1f-0a9b8c7d6e55",
+	}, cs.imageIDs())
+	cs.Assert().Contains(cs.out.String(), "Groups:\n  gitlab_dev: kept 2, deleted 1\n  runner: kept 1, deleted 1\nTotal: kept 3, deleted 2\n")
+}
+
+func (cs *CleanupSuite) TestRunNameRegex() {
+	cs.addRunners()
+	cs.cleanup.nameRegex = "^runner"
+	cs.cleanup.dryRun = true
+
+	err := cs.cleanup.Run(testContext(cs.out))
+
+	cs.Require().NoError(err)
+	cs.Assert().Contains(cs.out.String(), "Images for deletion:\n  7f3a1c55-2d4e-4b8f-9a6c-1e2d3f4a5b66\n")
+	cs.Assert().Contains(cs.out.String(), "Groups:\n  runner: kept 1, deleted 1\n  runner_arm: kept 1, deleted 0\nTotal: kept 2, deleted 1\n")
+	cs.Assert().NotContains(cs.out.String(), "gitlab_dev")
+}
+
+func (cs *CleanupSuite) addFamilies() {
+	cs.store.Add(images.Image{
+		ID:         "1a2b3c4d-0000-4000-8000-000000000001",
+		Name:       "gitlab_16.2.2-101",
+		Tags:       []string{"family=gitlab"},
+		Properties: map[string]interface{}{"image_family": "gitlab"},
+		CreatedAt:  time.Now(),
+	}, images.Image{
+		ID:         "1a2b3c4d-0000-4000-8000-000000000002",
+		Name:       "gitlab_16.2.2-100",
+		Tags:       []string{"family=gitlab"},
+		Properties: map[string]interface{}{"image_family": "gitlab"},
+		CreatedAt:  time.Now().Add(-time.Hour),
+	}, images.Image{
+		ID:         "1a2b3c4d-0000-4000-8000-000000000003",
+		Name:       "runner_17-7",
+		Tags:       []string{"family=runner"},
+		Properties: map[string]interface{}{"image_family": "runner"},
+		CreatedAt:  time.Now().Add(-time.Hour),
+	})
+}
+
+func (cs *CleanupSuite) TestRunGroupBy() {
+	for _, groupBy := range []string{"property:image_family", "tag-prefix:family="} {
+		cs.SetupTest()
+		cs.addFamilies()
+		cs.cleanup.groupBy = groupBy
+
+		err := cs.cleanup.Run(testContext(cs.out))
+
+		cs.Require().NoError(err, groupBy)
+		cs.Assert().Equal([]string{
+			"b9551daf-10df-4739-82a0-b7efc687e9c6",
+			"a66e2ab7-3de5-4cf3-bd24-104ccb511c8c",
+			"5beb9780-8eed-480f-807f-7a99c89174f2",
+			"04f24cb4-beb0-4d87-b67a-d4834fba08ab",
+			"1a2b3c4d-0000-4000-8000-000000000001",
+			"1a2b3c4d-0000-4000-8000-000000000003",
+		}, cs.imageIDs(), groupBy)
+		cs.Assert().Contains(cs.out.String(), "Groups:\n  gitlab: kept 1, deleted 1\n  runner: kept 1, deleted 0\n", groupBy)
+	}
+}
+
+func (cs *CleanupSuite) TestRunGroupByUnknown() {
+	cs.cleanup.groupBy = "label:family"
+
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().EqualError(err, `unknown group-by "label:family", expected name, name-family, property:<key> or tag-prefix:<prefix>`)
+}
+
+func (cs *CleanupSuite) TestRunKeepPatches() {
+	for idx, name := range []string{"gitlab_dev_16.3.0", "gitlab_dev_16.2.2", "gitlab_dev_16.2.1", "gitlab_dev_16.1.0"} {
+		cs.store.Add(images.Image{
+			ID:        fmt.Sprintf("5c6d7e8f-0000-4000-8000-00000000000%d", idx),
+			Name:      name,
+			CreatedAt: time.Now().Add(-time.Duration(idx) * time.Hour),
+		})
+	}
+	cs.cleanup.nameRegex = "^gitlab_dev_"
+	cs.cleanup.groupBy = groupByNameFamily
+	cs.cleanup.keepPatches = 1
+	cs.cleanup.keepMinors = 2
+	cs.cleanup.versionFrom = "name"
+	cs.cleanup.explain = true
+	cs.cleanup.dryRun = true
+
+	err := cs.cleanup.Run(testContext(cs.out))
+
+	cs.Require().NoError(err)
+	cs.Assert().Contains(cs.out.String(), "version 16.2.2 is one of 1 newest patches of 16.2 (rule semver)")
+	cs.Assert().Contains(cs.out.String(), "Images for deletion:\n  5c6d7e8f-0000-4000-8000-000000000002")
+	cs.Assert().NotContains(cs.out.String(), "Images for deletion:\n  5c6d7e8f-0000-4000-8000-000000000001")
+}
+
+func (cs *CleanupSuite) TestRunVersionFromUnknown() {
+	cs.cleanup.keepPatches = 1
+	cs.cleanup.keepMinors = 1
+	cs.cleanup.versionFrom = "label"
+
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().EqualError(err, `unknown version source "label", expected name, tag or property:<key>`)
+}
+
+func (cs *CleanupSuite) TestRunCommitTagPattern() {
+	cs.store.Add(images.Image{
+		ID:        "6d7e8f90-0000-4000-8000-000000000001",
+		Name:      "packer",
+		CreatedAt: time.Now(),
+	}, images.Image{
+		ID:         "6d7e8f90-0000-4000-8000-000000000002",
+		Name:       "packer",
+		Tags:       []string{"git-sha=f8b453a8"},
+		Properties: map[string]interface{}{"git_sha": "ad6fed94"},
+		CreatedAt:  time.Now().Add(-time.Hour),
+	}, images.Image{
+		ID:        "6d7e8f90-0000-4000-8000-000000000003",
+		Name:      "packer",
+		Tags:      []string{"git-sha=26190eb1"},
+		CreatedAt: time.Now().Add(-2 * time.Hour),
+	})
+	cs.cleanup.commitTagPattern = "git-sha=(.*)"
+	cs.cleanup.explain = true
+	cs.cleanup.dryRun = true
+
+	err := cs.cleanup.Run(testContext(cs.out, "packer"))
+
+	cs.Require().NoError(err)
+	cs.Assert().Contains(cs.out.String(), "Reason: matches commit f8b453a8b9dd6fd431577a47ec48f4ecf1500689 at depth 1 (rule commit-in-history)")
+	cs.Assert().Contains(cs.out.String(), "Images for deletion:\n  6d7e8f90-0000-4000-8000-000000000003\n")
+
+	cs.out.Reset()
+	cs.cleanup.commitTagPattern = ""
+	cs.cleanup.commitProperty = "git_sha"
+
+	err = cs.cleanup.Run(testContext(cs.out, "packer"))
+
+	cs.Require().NoError(err)
+	cs.Assert().Contains(cs.out.String(), "Reason: matches commit ad6fed9464ef6f47b2d89ab856090d25c898d259 at depth 0 (rule commit-in-history)")
+}
+
+func (cs *CleanupSuite) TestRunCommitTagPatternInvalid() {
+	cs.cleanup.commitTagPattern = "git-sha=.*"
+
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().EqualError(err, `commit tag pattern "git-sha=.*" must have exactly one group`)
+}
+
+func (cs *CleanupSuite) TestRunInUse() {
+	cs.cleanup.conn = usedConnector{
+		memoryConnector: memoryConnector{"": cs.store},
+		used:            usage.Memory{"a66e2ab7-3de5-4cf3-bd24-104ccb511c8c": "server web-1"},
+	}
+	cs.cleanup.checkInUse = true
+	cs.cleanup.explain = true
+
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().NoError(err)
+	cs.Assert().Contains(cs.out.String(), "Reason: in use by server web-1 (rule in-use)")
+	cs.Assert().Contains(cs.imageIDs(), "a66e2ab7-3de5-4cf3-bd24-104ccb511c8c")
+}
+
+func (cs *CleanupSuite) TestRunProtected() {
+	cs.store.Add(images.Image{
+		ID:        "7e8f9a0b-0000-4000-8000-000000000001",
+		Name:      "gitlab_dev",
+		Protected: true,
+		CreatedAt: time.Now().Add(-4 * time.Hour),
+	})
+	cs.cleanup.explain = true
+
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().NoError(err)
+	cs.Assert().Contains(cs.out.String(), "Reason: protected (rule protected)")
+	cs.Assert().Contains(cs.imageIDs(), "7e8f9a0b-0000-4000-8000-000000000001")
+
+	cs.cleanup.unprotect = true
+
+	err = cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().NoError(err)
+	cs.Assert().NotContains(cs.imageIDs(), "7e8f9a0b-0000-4000-8000-000000000001")
+}
+
+func (cs *CleanupSuite) TestRunProtectedPolicy() {
+	cs.store.Add(images.Image{
+		ID:        "7e8f9a0b-0000-4000-8000-000000000001",
+		Name:      "gitlab_dev",
+		Protected: true,
+		CreatedAt: time.Now().Add(-4 * time.Hour),
+	})
+	cs.cleanup.policyFile = filepath.Join(cs.T().TempDir(), "policy.yaml")
+	cs.Require().NoError(os.WriteFile(cs.cleanup.policyFile, []byte("rules: [{type: latest}]\n"), 0o600))
+
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().NoError(err)
+	cs.Assert().Equal(retention.RuleProtected, cs.cleanup.policy.Rules[0].Name())
+	cs.Assert().Contains(cs.imageIDs(), "7e8f9a0b-0000-4000-8000-000000000001")
+}
+
+func (cs *CleanupSuite) TestRunProtectedAfterDeleteRule() {
+	cs.store.Add(images.Image{
+		ID:        "7e8f9a0b-0000-4000-8000-000000000001",
+		Name:      "gitlab_dev",
+		Protected: true,
+		CreatedAt: time.Now().Add(-4 * time.Hour),
+	})
+	cs.cleanup.policyFile = filepath.Join(cs.T().TempDir(), "policy.yaml")
+	cs.Require().NoError(os.WriteFile(cs.cleanup.policyFile, []byte("rules: [{type: age, max: 3h}, {type: protected}]\n"), 0o600))
+	cs.cleanup.explain = true
+
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().NoError(err)
+	cs.Assert().Contains(cs.imageIDs(), "7e8f9a0b-0000-4000-8000-000000000001")
+	cs.Assert().Contains(cs.cleanup.savedImages, "7e8f9a0b-0000-4000-8000-000000000001")
+	cs.Assert().NotContains(cs.cleanup.imagesForDeletion, "7e8f9a0b-0000-4000-8000-000000000001")
+	cs.Assert().Contains(cs.out.String(), "Reason: protected, use --unprotect to delete it (rule protected)")
+	cs.Assert().Equal(len(cs.cleanup.imagesForDeletion), cs.cleanup.groups[0].Deleted)
+	for _, d := range cs.cleanup.decisions {
+		if d.Image.ID == "7e8f9a0b-0000-4000-8000-000000000001" {
+			cs.Assert().True(d.Keep)
+			cs.Assert().Equal(retention.RuleProtected, d.Rule)
+		}
+	}
+}
+
+func (cs *CleanupSuite) TestRunContinueOnError() {
+	cs.store.Add(images.Image{
+		ID:        "8f9a0b1c-0000-4000-8000-000000000001",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now().Add(-4 * time.Hour),
+	})
+	cs.cleanup.conn = failingConnector{
+		memoryConnector: memoryConnector{"": cs.store},
+		fail: map[string]error{
+			"a66e2ab7-3de5-4cf3-bd24-104ccb511c8c": gophercloud.ErrDefault500{
+				ErrUnexpectedResponseCode: gophercloud.ErrUnexpectedResponseCode{Actual: http.StatusInternalServerError},
+			},
+		},
+	}
+	cs.cleanup.continueOnError = true
+
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().EqualError(err, "failed to delete 1 images")
+	cs.Assert().NotContains(cs.imageIDs(), "8f9a0b1c-0000-4000-8000-000000000001")
+	cs.Assert().Contains(cs.out.String(), "Failed to delete 1 images:\n  a66e2ab7-3de5-4cf3-bd24-104ccb511c8c: HTTP 500 Internal Server Error\n")
+}
+
+func (cs *CleanupSuite) TestRunParallel() {
+	cs.store.Add(images.Image{
+		ID:        "8f9a0b1c-0000-4000-8000-000000000003",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now().Add(-4 * time.Hour),
+	}, images.Image{
+		ID:        "8f9a0b1c-0000-4000-8000-000000000002",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now().Add(-5 * time.Hour),
+	}, images.Image{
+		ID:        "8f9a0b1c-0000-4000-8000-000000000001",
+		Name:      "gitlab_dev",
+		CreatedAt: time.Now().Add(-6 * time.Hour),
+	})
+	fail := gophercloud.ErrDefault500{
+		ErrUnexpectedResponseCode: gophercloud.ErrUnexpectedResponseCode{Actual: http.StatusInternalServerError},
+	}
+	cs.cleanup.conn = failingConnector{
+		memoryConnector: memoryConnector{"": cs.store},
+		fail: map[string]error{
+			"8f9a0b1c-0000-4000-8000-000000000003": fail,
+			"8f9a0b1c-0000-4000-8000-000000000001": fail,
+		},
+	}
+	cs.cleanup.parallel = 4
+	cs.cleanup.continueOnError = true
+
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().EqualError(err, "failed to delete 2 images")
+	cs.Assert().NotContains(cs.imageIDs(), "8f9a0b1c-0000-4000-8000-000000000002")
+	cs.Assert().NotContains(cs.imageIDs(), "a66e2ab7-3de5-4cf3-bd24-104ccb511c8c")
+	cs.Assert().Contains(cs.out.String(), "Failed to delete 2 images:\n"+
+		"  8f9a0b1c-0000-4000-8000-000000000001: HTTP 500 Internal Server Error\n"+
+		"  8f9a0b1c-0000-4000-8000-000000000003: HTTP 500 Internal Server Error\n")
+}
+
+func (cs *CleanupSuite) TestRunParallelNegative() {
+	cs.cleanup.parallel = -1
+
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().EqualError(err, "parallel must not be negative, got -1")
+	cs.Assert().Len(cs.imageIDs(), 4)
+}
+
+func (cs *CleanupSuite) TestRunBranches() {
+	cs.store.Add(images.Image{
+		ID:         "3e4f5a6b-0000-4000-8000-000000000001",
+		Name:       "gitlab_dev",
+		Tags:       []string{"release/16.2"},
+		Visibility: images.ImageVisibilityPrivate,
+		CreatedAt:  time.Now().Add(-time.Hour * 3),
+	}, images.Image{
+		ID:         "3e4f5a6b-0000-4000-8000-000000000002",
+		Name:       "gitlab_dev",
+		Tags:       []string{"release/16.2"},
+		Visibility: images.ImageVisibilityPrivate,
+		CreatedAt:  time.Now().Add(-time.Hour * 4),
+	}, images.Image{
+		ID:         "3e4f5a6b-0000-4000-8000-000000000003",
+		Name:       "gitlab_dev",
+		Tags:       []string{"feature"},
+		Visibility: images.ImageVisibilityPrivate,
+		CreatedAt:  time.Now().Add(-time.Hour * 5),
+	})
+	cs.cleanup.branchAware = true
+	cs.cleanup.currentBranch = func() (string, error) {
+		return "master", nil
+	}
+
+	// images of other branches are kept without heads
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().NoError(err)
+	cs.Assert().Len(cs.imageIDs(), 6)
+
+	// older images of heads are pruned like images of the current branch
+	cs.Require().NoError(cs.cleanup.branches.Set("release/*"))
+	err = cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().NoError(err)
+	cs.Assert().Equal([]string{
+		"b9551daf-10df-4739-82a0-b7efc687e9c6",
+		"5beb9780-8eed-480f-807f-7a99c89174f2",
+		"04f24cb4-beb0-4d87-b67a-d4834fba08ab",
+		"3e4f5a6b-0000-4000-8000-000000000001",
+		"3e4f5a6b-0000-4000-8000-000000000003",
+	}, cs.imageIDs())
+}
+
+func (cs *CleanupSuite) TestRunBranchDetached() {
+	cs.cleanup.branchAware = true
+	cs.cleanup.currentBranch = func() (string, error) {
+		return "HEAD", nil
+	}
+
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+	cs.Require().EqualError(err, "HEAD is detached, set the branch with --branch")
+
+	cs.cleanup.branch = "master"
+	cs.Require().NoError(cs.cleanup.Run(testContext(cs.out, "gitlab_dev")))
+	cs.Assert().Len(cs.imageIDs(), 3)
+}
+
+func (cs *CleanupSuite) TestRunNamesAndRegex() {
+	cs.cleanup.nameRegex = "^runner"
+
+	err := cs.cleanup.Run(testContext(cs.out, "gitlab_dev"))
+
+	cs.Require().EqualError(err, "image names and --name-regex can't be used together")
+}
+
+func (cs *CleanupSuite) TestRunWithoutName() {
+	err := cs.cleanup.Run(testContext(cs.out))
+
+	cs.Require().Error(err)
+	cs.Assert().Len(cs.imageIDs(), 4)
+}
@@ -3,11 +3,7 @@ package action
 import (
 	"context"
 	"fmt"
-	"os"
 
-	"github.com/gophercloud/gophercloud"
-	"github.com/gophercloud/gophercloud/openstack"
-	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
 	log "github.com/hornwind/openstack-image-keeper/pkg/logging"
 	"github.com/urfave/cli/v2"
 )
@@ -16,10 +12,11 @@ var _ Action = (*DeleteByID)(nil)
 
 // DeleteByID is a struct for running 'delete' command.
 type DeleteByID struct {
-	authOpts *gophercloud.AuthOptions
-	provider *gophercloud.ProviderClient
-	eo       *gophercloud.EndpointOpts
-	loglevel string
+	conn            connector
+	failures        deleteFailures
+	loglevel        string
+	parallel        int
+	continueOnError bool
 }
 
 // Run is the main function for 'delete' command.
@@ -29,19 +26,6 @@ func (d *DeleteByID) Run(ctx context.Context) error {
 		return err
 	}
 
-	ao, err := openstack.AuthOptionsFromEnv()
-	if err != nil {
-		return err
-	}
-	d.authOpts = &ao
-	d.provider, err = openstack.AuthenticatedClient(*d.authOpts)
-	if err != nil {
-		return err
-	}
-	d.eo = &gophercloud.EndpointOpts{
-		Region: os.Getenv("OS_REGION_NAME"),
-	}
-
 	idList, ok := ctx.Value("allArgs").([]string)
 	if !ok {
 		msg := "Image args list assertion failed"
@@ -49,22 +33,78 @@ func (d *DeleteByID) Run(ctx context.Context) error {
 		return err
 	}
 
+	if d.parallel < 0 {
+		return fmt.Errorf("parallel must not be negative, got %d", d.parallel)
+	}
+
+	var err error
+	d.conn, err = connect(ctx, d.conn)
+	if err != nil {
+		return err
+	}
+
 	err = d.deleteImages(ctx, idList)
 	return err
 }
 
 func (d *DeleteByID) deleteImages(ctx context.Context, idList []string) error {
 	log := log.GetLogger()
-	client, _ := openstack.NewImageServiceV2(d.provider, *d.eo)
+	store, err := d.conn.ImageStore("")
+	if err != nil {
+		return err
+	}
+	store = withRetries(ctx, store)
+
+	d.failures = nil
+	errs := parallel(ctx, d.parallel, len(idList), !d.continueOnError, func(ctx context.Context, idx int) error {
+		return store.Delete(ctx, idList[idx])
+	})
+	report := deleteReport{Deleted: []string{}, Skipped: []string{}}
+	var first error
+	for idx, err := range errs {
+		id := idList[idx]
+		switch {
+		case err == nil:
+			log.Debugf("Deleted image %s", id)
+			report.Deleted = append(report.Deleted, id)
+		case err == errNotStarted:
+			report.Skipped = append(report.Skipped, id)
+		default:
+			log.Errorf("Delete image %s: %s", id, err)
+			d.failures.add("", id, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+
+	if d.continueOnError || first == nil {
+		return d.report(ctx, report, d.failures.err())
+	}
+	return d.report(ctx, report, first)
+}
 
-	for _, id := range idList {
-		result := images.Delete(client, id)
-		log.Debug(result.Result)
-		if result.Err != nil {
-			return result.Err
+// report writes the document with --output json or yaml, otherwise the
+// summary of failures and images not attempted after a failure, and returns err.
+func (d *DeleteByID) report(ctx context.Context, report deleteReport, err error) error {
+	if !structured(ctx) {
+		out := outputWriter(ctx)
+		d.failures.print(out)
+		if len(report.Skipped) > 0 {
+			fmt.Fprintf(out, "Not attempted after the failure, %d images:\n", len(report.Skipped))
+			for _, id := range report.Skipped {
+				fmt.Fprintf(out, "  %s\n", id)
+			}
 		}
+		return err
 	}
-	return nil
+
+	report.Failed = append([]deleteFailure{}, d.failures...)
+	if werr := writeDocument(ctx, report); werr != nil {
+		return werr
+	}
+
+	return err
 }
 
 // Cmd returns 'delete' *cli.Command.
@@ -81,6 +121,8 @@ func (d *DeleteByID) Cmd() *cli.Command {
 // flags return flag set of CLI urfave.
 func (d *DeleteByID) flags() []cli.Flag {
 	self := []cli.Flag{
+		flagParallel(&d.parallel),
+		flagContinueOnError(&d.continueOnError),
 		flagLogLevel(&d.loglevel),
 	}
 
@@ -0,0 +1,105 @@
+package action
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/hornwind/openstack-image-keeper/pkg/imagestore"
+	"github.com/stretchr/testify/suite"
+)
+
+type DeleteSuite struct {
+	suite.Suite
+	delete *DeleteByID
+	store  *imagestore.Memory
+}
+
+func TestDelete(t *testing.T) {
+	suite.Run(t, &DeleteSuite{})
+}
+
+func (ds *DeleteSuite) SetupTest() {
+	ds.store = imagestore.NewMemory(images.Image{
+		ID:        "f25148bb-fc89-4787-abfa-4889e455c3f8",
+		CreatedAt: time.Now(),
+	}, images.Image{
+		ID:        "8b2d978b-da7f-4ddd-839e-27fbbecb4de2",
+		CreatedAt: time.Now(),
+	}, images.Image{
+		ID:        "c0e3c9f4-4a0e-4e47-9a5a-6f1f5d1b7a33",
+		CreatedAt: time.Now(),
+	})
+	ds.delete = &DeleteByID{
+		conn:     memoryConnector{"": ds.store},
+		loglevel: "error",
+	}
+}
+
+func (ds *DeleteSuite) TestRun() {
+	ctx := testContext(&bytes.Buffer{}, "f25148bb-fc89-4787-abfa-4889e455c3f8", "8b2d978b-da7f-4ddd-839e-27fbbecb4de2")
+
+	err := ds.delete.Run(ctx)
+
+	ds.Require().NoError(err)
+	ds.Require().Len(ds.store.Images(), 1)
+	ds.Assert().Equal("c0e3c9f4-4a0e-4e47-9a5a-6f1f5d1b7a33", ds.store.Images()[0].ID)
+}
+
+func (ds *DeleteSuite) TestRunNotFound() {
+	ctx := testContext(&bytes.Buffer{}, "00000000-0000-0000-0000-000000000000")
+
+	err := ds.delete.Run(ctx)
+
+	ds.Require().Error(err)
+	ds.Assert().Len(ds.store.Images(), 3)
+}
+
+func (ds *DeleteSuite) TestRunStopOnError() {
+	out := &bytes.Buffer{}
+	ctx := testContext(out, "f25148bb-fc89-4787-abfa-4889e455c3f8", "00000000-0000-0000-0000-000000000000", "8b2d978b-da7f-4ddd-839e-27fbbecb4de2")
+
+	err := ds.delete.Run(ctx)
+
+	ds.Require().Error(err)
+	ds.Assert().Len(ds.store.Images(), 2)
+	ds.Require().Len(ds.delete.failures, 1)
+	ds.Assert().Equal(404, ds.delete.failures[0].Status)
+	ds.Assert().Equal("Failed to delete 1 images:\n  00000000-0000-0000-0000-000000000000: HTTP 404 Not Found\n"+
+		"Not attempted after the failure, 1 images:\n  8b2d978b-da7f-4ddd-839e-27fbbecb4de2\n", out.String())
+}
+
+func (ds *DeleteSuite) TestRunContinueOnError() {
+	out := &bytes.Buffer{}
+	ctx := testContext(out, "00000000-0000-0000-0000-000000000000", "f25148bb-fc89-4787-abfa-4889e455c3f8")
+	ds.delete.continueOnError = true
+
+	err := ds.delete.Run(ctx)
+
+	ds.Require().EqualError(err, "failed to delete 1 images")
+	ds.Assert().Len(ds.store.Images(), 2)
+	ds.Assert().Equal("Failed to delete 1 images:\n  00000000-0000-0000-0000-000000000000: HTTP 404 Not Found\n", out.String())
+}
+
+func (ds *DeleteSuite) TestRunParallel() {
+	out := &bytes.Buffer{}
+	ctx := testContext(out, "00000000-0000-0000-0000-000000000002", "f25148bb-fc89-4787-abfa-4889e455c3f8", "00000000-0000-0000-0000-000000000001", "8b2d978b-da7f-4ddd-839e-27fbbecb4de2")
+	ds.delete.parallel = 4
+	ds.delete.continueOnError = true
+
+	err := ds.delete.Run(ctx)
+
+	ds.Require().EqualError(err, "failed to delete 2 images")
+	ds.Assert().Len(ds.store.Images(), 1)
+	ds.Assert().Equal("Failed to delete 2 images:\n  00000000-0000-0000-0000-000000000002: HTTP 404 Not Found\n  00000000-0000-0000-0000-000000000001: HTTP 404 Not Found\n", out.String())
+}
+
+func (ds *DeleteSuite) TestRunParallelNegative() {
+	ds.delete.parallel = -1
+
+	err := ds.delete.Run(testContext(&bytes.Buffer{}, "f25148bb-fc89-4787-abfa-4889e455c3f8"))
+
+	ds.Require().EqualError(err, "parallel must not be negative, got -1")
+	ds.Assert().Len(ds.store.Images(), 3)
+}
@@ -0,0 +1,70 @@
+package action
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// deleteFailure is an image --continue-on-error failed to delete.
+type deleteFailure struct {
+	Region string `json:"region" yaml:"region"`
+	ID     string `json:"id" yaml:"id"`
+	Status int    `json:"status,omitempty" yaml:"status,omitempty"`
+	Error  string `json:"error" yaml:"error"`
+}
+
+// String describes the failure in one line, the body of the response is left to logs.
+func (f deleteFailure) String() string {
+	id := f.ID
+	if f.Region != "" {
+		id = fmt.Sprintf("%s (%s)", f.ID, f.Region)
+	}
+	if f.Status != 0 {
+		return fmt.Sprintf("%s: HTTP %d %s", id, f.Status, http.StatusText(f.Status))
+	}
+
+	return fmt.Sprintf("%s: %s", id, f.Error)
+}
+
+// deleteFailures collects failed deletions of a command.
+type deleteFailures []deleteFailure
+
+// add records failed deletion of image id in region.
+func (f *deleteFailures) add(region, id string, err error) {
+	failure := deleteFailure{
+		Region: region,
+		ID:     id,
+		Error:  err.Error(),
+	}
+	var statusErr gophercloud.StatusCodeError
+	if errors.As(err, &statusErr) {
+		failure.Status = statusErr.GetStatusCode()
+	}
+
+	*f = append(*f, failure)
+}
+
+// print writes the summary of failures.
+func (f deleteFailures) print(w io.Writer) {
+	if len(f) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "Failed to delete %d images:\n", len(f))
+	for _, failure := range f {
+		fmt.Fprintf(w, "  %s\n", failure)
+	}
+}
+
+// err returns an error if any deletion failed.
+func (f deleteFailures) err() error {
+	if len(f) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("failed to delete %d images", len(f))
+}
@@ -1,7 +1,13 @@
 package action
 
 import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/hornwind/openstack-image-keeper/pkg/logging"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/slices"
 )
 
 // flagScanDepth pass val to urfave flag.
@@ -26,6 +32,48 @@ func flagScanDepth(v *int) *cli.IntFlag {
 	}
 }
 
+// flagKeepLast pass val to urfave flag.
+func flagKeepLast(v *int) *cli.IntFlag {
+	return &cli.IntFlag{
+		Name:        "keep-last",
+		Usage:       "always keep N newest private images regardless of tags",
+		Value:       1,
+		EnvVars:     []string{"HOUSEKEEPER_KEEP_LAST"},
+		Destination: v,
+	}
+}
+
+// flagMinAge pass val to urfave flag.
+func flagMinAge(v *time.Duration) *cli.GenericFlag {
+	return &cli.GenericFlag{
+		Name:    "min-age",
+		Usage:   "never delete images younger than age, e.g. 72h or 3d",
+		EnvVars: []string{"HOUSEKEEPER_MIN_AGE"},
+		Value:   ageValue{v},
+	}
+}
+
+// flagMaxAge pass val to urfave flag.
+func flagMaxAge(v *time.Duration) *cli.GenericFlag {
+	return &cli.GenericFlag{
+		Name:    "max-age",
+		Usage:   "delete images older than age, e.g. 90d, unless an earlier rule of the default policy keeps them",
+		EnvVars: []string{"HOUSEKEEPER_MAX_AGE"},
+		Value:   ageValue{v},
+	}
+}
+
+// flagPolicy pass val to urfave flag.
+func flagPolicy(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "policy",
+		Usage:       "YAML file with retention rules, it replaces --keep-last, --min-age and --max-age",
+		EnvVars:     []string{"HOUSEKEEPER_POLICY"},
+		TakesFile:   true,
+		Destination: v,
+	}
+}
+
 // flagDryRun pass val to urfave flag.
 func flagDryRun(v *bool) *cli.BoolFlag {
 	return &cli.BoolFlag{
@@ -37,6 +85,17 @@ func flagDryRun(v *bool) *cli.BoolFlag {
 	}
 }
 
+// flagExplain pass val to urfave flag.
+func flagExplain(v *bool) *cli.BoolFlag {
+	return &cli.BoolFlag{
+		Name:        "explain",
+		Usage:       "print name, creation time, tags and the reason to keep or delete every image",
+		Value:       false,
+		EnvVars:     []string{"HOUSEKEEPER_EXPLAIN"},
+		Destination: v,
+	}
+}
+
 // flagDryRun pass val to urfave flag.
 func flagProtected(v *bool) *cli.BoolFlag {
 	return &cli.BoolFlag{
@@ -58,3 +117,452 @@ func flagHidden(v *bool) *cli.BoolFlag {
 		Destination: v,
 	}
 }
+
+// flagListFormat pass val to urfave flag.
+func flagListFormat(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "format",
+		Usage:       "text layout of images: block or table",
+		Value:       listFormatBlock,
+		EnvVars:     []string{"HOUSEKEEPER_LIST_FORMAT"},
+		Destination: v,
+	}
+}
+
+// flagColumns pass val to urfave flag.
+func flagColumns(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "columns",
+		Usage:       "comma separated columns of table format: id, name, created, updated, visibility, status, size, protected, hidden, checksum, tags (default: " + defaultColumns + ")",
+		EnvVars:     []string{"HOUSEKEEPER_COLUMNS"},
+		Destination: v,
+	}
+}
+
+// flagTemplate pass val to urfave flag.
+func flagTemplate(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "template",
+		Usage:       "Go template rendered for every image, fields of gophercloud images.Image are available",
+		EnvVars:     []string{"HOUSEKEEPER_TEMPLATE"},
+		Destination: v,
+	}
+}
+
+// flagTemplateFile pass val to urfave flag.
+func flagTemplateFile(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "template-file",
+		Usage:       "file with Go template rendered for every image",
+		EnvVars:     []string{"HOUSEKEEPER_TEMPLATE_FILE"},
+		TakesFile:   true,
+		Destination: v,
+	}
+}
+
+// flagOSCloud pass val to urfave flag.
+func flagOSCloud() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:    "os-cloud",
+		Usage:   "cloud name from clouds.yaml, OS_* variables are used when empty",
+		EnvVars: []string{"OS_CLOUD"},
+	}
+}
+
+// flagOutput pass val to urfave flag.
+func flagOutput() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Usage:   "output format: text, json or yaml",
+		Value:   outputText,
+		EnvVars: []string{"HOUSEKEEPER_OUTPUT"},
+		Action: func(_ *cli.Context, v string) error {
+			if !slices.Contains([]string{outputText, outputJSON, outputYAML}, v) {
+				return fmt.Errorf("unknown output format %q, expected text, json or yaml", v)
+			}
+			// keep stdout parseable, logs go to stderr with documents
+			if v != outputText {
+				log.GetLogger().SetOutput(os.Stderr)
+			}
+			return nil
+		},
+	}
+}
+
+// flagRetries pass val to urfave flag.
+func flagRetries() *cli.IntFlag {
+	return &cli.IntFlag{
+		Name:    "retries",
+		Usage:   "number of times Glance calls failed with 409, 413, 429, 5xx or connection reset are repeated, 0 disables",
+		Value:   3,
+		EnvVars: []string{"HOUSEKEEPER_RETRIES"},
+		Action: func(_ *cli.Context, v int) error {
+			if v < 0 {
+				return fmt.Errorf("retries must not be negative, got %d", v)
+			}
+			return nil
+		},
+	}
+}
+
+// flagRetryBackoff pass val to urfave flag.
+func flagRetryBackoff() *cli.DurationFlag {
+	return &cli.DurationFlag{
+		Name:    "retry-backoff",
+		Usage:   "delay before the first retry, doubled for every next one, Retry-After of 413 and 429 responses replaces it",
+		Value:   time.Second,
+		EnvVars: []string{"HOUSEKEEPER_RETRY_BACKOFF"},
+	}
+}
+
+// GlobalFlags returns flags shared by all commands.
+func GlobalFlags() []cli.Flag {
+	return []cli.Flag{
+		flagOSCloud(),
+		flagOutput(),
+		flagRetries(),
+		flagRetryBackoff(),
+	}
+}
+
+// flagRegions pass val to urfave flag.
+func flagRegions(v *cli.StringSlice) *cli.StringSliceFlag {
+	return &cli.StringSliceFlag{
+		Name:        "regions",
+		Usage:       "comma separated regions to run in, 'all' for every region from catalog (default: OS_REGION_NAME)",
+		EnvVars:     []string{"HOUSEKEEPER_REGIONS"},
+		Destination: v,
+	}
+}
+
+// flagName pass val to urfave flag.
+func flagName(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "name",
+		Usage:       "list images with exact name",
+		EnvVars:     []string{"HOUSEKEEPER_NAME"},
+		Destination: v,
+	}
+}
+
+// flagNameRegex pass val to urfave flag.
+func flagNameRegex(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "name-regex",
+		Usage:       "select images with name matching regular expression",
+		EnvVars:     []string{"HOUSEKEEPER_NAME_REGEX"},
+		Destination: v,
+	}
+}
+
+// flagTag pass val to urfave flag.
+func flagTag(v *cli.StringSlice) *cli.StringSliceFlag {
+	return &cli.StringSliceFlag{
+		Name:        "tag",
+		Usage:       "list images with all of the tags",
+		EnvVars:     []string{"HOUSEKEEPER_TAG"},
+		Destination: v,
+	}
+}
+
+// flagProperty pass val to urfave flag.
+func flagProperty(v *cli.StringSlice) *cli.StringSliceFlag {
+	return &cli.StringSliceFlag{
+		Name:        "property",
+		Usage:       "list images with all of the key=value properties",
+		EnvVars:     []string{"HOUSEKEEPER_PROPERTY"},
+		Destination: v,
+	}
+}
+
+// flagVisibility pass val to urfave flag.
+func flagVisibility(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "visibility",
+		Usage:       "list images with visibility: public, private, shared or community",
+		EnvVars:     []string{"HOUSEKEEPER_VISIBILITY"},
+		Destination: v,
+	}
+}
+
+// flagStatus pass val to urfave flag.
+func flagStatus(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "status",
+		Usage:       "list images with status, e.g. active or queued",
+		EnvVars:     []string{"HOUSEKEEPER_STATUS"},
+		Destination: v,
+	}
+}
+
+// flagCreatedBefore pass val to urfave flag.
+func flagCreatedBefore(v *time.Time) *cli.GenericFlag {
+	return &cli.GenericFlag{
+		Name:    "created-before",
+		Usage:   "list images created before RFC 3339 time, YYYY-MM-DD date or age like 30d",
+		EnvVars: []string{"HOUSEKEEPER_CREATED_BEFORE"},
+		Value:   dateValue{v},
+	}
+}
+
+// flagCreatedAfter pass val to urfave flag.
+func flagCreatedAfter(v *time.Time) *cli.GenericFlag {
+	return &cli.GenericFlag{
+		Name:    "created-after",
+		Usage:   "list images created after RFC 3339 time, YYYY-MM-DD date or age like 7d",
+		EnvVars: []string{"HOUSEKEEPER_CREATED_AFTER"},
+		Value:   dateValue{v},
+	}
+}
+
+// flagSort pass val to urfave flag.
+func flagSort(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "sort",
+		Usage:       "Glance sort order, e.g. created_at:desc or name:asc,created_at:desc",
+		EnvVars:     []string{"HOUSEKEEPER_SORT"},
+		Destination: v,
+	}
+}
+
+// flagGroupBy pass val to urfave flag.
+func flagGroupBy(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "group-by",
+		Usage:       "apply retention per group of images: name, name-family (name without version), property:<key> or tag-prefix:<prefix>",
+		Value:       groupByName,
+		EnvVars:     []string{"HOUSEKEEPER_GROUP_BY"},
+		Destination: v,
+	}
+}
+
+// flagBranchAware pass val to urfave flag.
+func flagBranchAware(v *bool) *cli.BoolFlag {
+	return &cli.BoolFlag{
+		Name:        "branch-aware",
+		Usage:       "prune only images tagged with the current branch or --branches, keep the newest image of each branch",
+		Value:       false,
+		EnvVars:     []string{"HOUSEKEEPER_BRANCH_AWARE"},
+		Destination: v,
+	}
+}
+
+// flagBranch pass val to urfave flag.
+func flagBranch(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "branch",
+		Usage:       "current branch for branch aware cleanup, e.g. in detached HEAD (default: branch of git HEAD)",
+		EnvVars:     []string{"HOUSEKEEPER_BRANCH"},
+		Destination: v,
+	}
+}
+
+// flagBranches pass val to urfave flag.
+func flagBranches(v *cli.StringSlice) *cli.StringSliceFlag {
+	return &cli.StringSliceFlag{
+		Name:        "branches",
+		Usage:       "comma separated branch patterns like main,release/* to prune like the current branch keeping their newest image, enables --branch-aware",
+		EnvVars:     []string{"HOUSEKEEPER_BRANCHES"},
+		Destination: v,
+	}
+}
+
+// flagKeepTags pass val to urfave flag.
+func flagKeepTags(v *cli.StringSlice) *cli.StringSliceFlag {
+	return &cli.StringSliceFlag{
+		Name:        "keep-tags",
+		Usage:       "comma separated git tag patterns like v*, images tagged with matching tags or their commits are never deleted",
+		EnvVars:     []string{"HOUSEKEEPER_KEEP_TAGS"},
+		Destination: v,
+	}
+}
+
+// flagKeepPatches pass val to urfave flag.
+func flagKeepPatches(v *int) *cli.IntFlag {
+	return &cli.IntFlag{
+		Name:        "keep-patches",
+		Usage:       "keep the newest image of N newest patch versions of every kept minor version, 0 disables",
+		Value:       0,
+		EnvVars:     []string{"HOUSEKEEPER_KEEP_PATCHES"},
+		Destination: v,
+	}
+}
+
+// flagKeepMinors pass val to urfave flag.
+func flagKeepMinors(v *int) *cli.IntFlag {
+	return &cli.IntFlag{
+		Name:        "keep-minors",
+		Usage:       "number of newest minor versions --keep-patches applies to",
+		Value:       1,
+		EnvVars:     []string{"HOUSEKEEPER_KEEP_MINORS"},
+		Destination: v,
+	}
+}
+
+// flagVersionFrom pass val to urfave flag.
+func flagVersionFrom(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "version-from",
+		Usage:       "where to read semantic versions of images: name, tag or property:<key>",
+		Value:       "name",
+		EnvVars:     []string{"HOUSEKEEPER_VERSION_FROM"},
+		Destination: v,
+	}
+}
+
+// flagGitRepo pass val to urfave flag.
+func flagGitRepo(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "git-repo",
+		Usage:       "path of git repository to read history from (default: current directory)",
+		EnvVars:     []string{"HOUSEKEEPER_GIT_REPO"},
+		Destination: v,
+	}
+}
+
+// flagGitURL pass val to urfave flag.
+func flagGitURL(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "git-url",
+		Usage:       "URL of git repository cloned in memory to read history from, e.g. https://gitlab.com/group/project.git",
+		EnvVars:     []string{"HOUSEKEEPER_GIT_URL"},
+		Destination: v,
+	}
+}
+
+// flagGitRef pass val to urfave flag.
+func flagGitRef(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "git-ref",
+		Usage:       "branch, tag or commit to scan history from (default: HEAD)",
+		EnvVars:     []string{"HOUSEKEEPER_GIT_REF"},
+		Destination: v,
+	}
+}
+
+// flagGitToken pass val to urfave flag.
+func flagGitToken(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "git-token",
+		Usage:       "access token to clone --git-url over HTTPS",
+		EnvVars:     []string{"HOUSEKEEPER_GIT_TOKEN"},
+		Destination: v,
+	}
+}
+
+// flagGitSSHKey pass val to urfave flag.
+func flagGitSSHKey(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "git-ssh-key",
+		Usage:       "private key file to clone --git-url over SSH (default: SSH agent)",
+		EnvVars:     []string{"HOUSEKEEPER_GIT_SSH_KEY"},
+		Destination: v,
+	}
+}
+
+// flagFirstParent pass val to urfave flag.
+func flagFirstParent(v *bool) *cli.BoolFlag {
+	return &cli.BoolFlag{
+		Name:        "first-parent",
+		Usage:       "scan only the first parent of merge commits, i.e. the mainline history",
+		Value:       false,
+		EnvVars:     []string{"HOUSEKEEPER_FIRST_PARENT"},
+		Destination: v,
+	}
+}
+
+// flagSince pass val to urfave flag.
+func flagSince(v *time.Time) *cli.GenericFlag {
+	return &cli.GenericFlag{
+		Name:    "since",
+		Usage:   "scan commits committed since RFC 3339 time, YYYY-MM-DD date or age like 30d instead of --scandepth",
+		EnvVars: []string{"HOUSEKEEPER_SINCE"},
+		Value:   dateValue{v},
+	}
+}
+
+// flagUntilCommit pass val to urfave flag.
+func flagUntilCommit(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "until-commit",
+		Usage:       "scan commits down to the commit with sha or sha prefix of 7 digits at least, inclusive, instead of --scandepth",
+		EnvVars:     []string{"HOUSEKEEPER_UNTIL_COMMIT"},
+		Destination: v,
+	}
+}
+
+// flagCommitTagPattern pass val to urfave flag.
+func flagCommitTagPattern(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "commit-tag-pattern",
+		Usage:       "regular expression of image tags with commit sha in its only group, e.g. 'git-sha=(.*)' (default: every tag)",
+		EnvVars:     []string{"HOUSEKEEPER_COMMIT_TAG_PATTERN"},
+		Destination: v,
+	}
+}
+
+// flagCommitProperty pass val to urfave flag.
+func flagCommitProperty(v *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "commit-property",
+		Usage:       "image property with commit sha the image was built from",
+		EnvVars:     []string{"HOUSEKEEPER_COMMIT_PROPERTY"},
+		Destination: v,
+	}
+}
+
+// flagCheckInUse pass val to urfave flag.
+func flagCheckInUse(v *bool) *cli.BoolFlag {
+	return &cli.BoolFlag{
+		Name:        "check-in-use",
+		Usage:       "keep images servers were booted from, disable with --check-in-use=false",
+		Value:       true,
+		EnvVars:     []string{"HOUSEKEEPER_CHECK_IN_USE"},
+		Destination: v,
+	}
+}
+
+// flagCheckVolumes pass val to urfave flag.
+func flagCheckVolumes(v *bool) *cli.BoolFlag {
+	return &cli.BoolFlag{
+		Name:        "check-volumes",
+		Usage:       "keep images volumes were created from too, requires --check-in-use",
+		Value:       false,
+		EnvVars:     []string{"HOUSEKEEPER_CHECK_VOLUMES"},
+		Destination: v,
+	}
+}
+
+// flagUnprotect pass val to urfave flag.
+func flagUnprotect(v *bool) *cli.BoolFlag {
+	return &cli.BoolFlag{
+		Name:        "unprotect",
+		Usage:       "let retention delete protected images, protection is cleared before deletion",
+		Value:       false,
+		EnvVars:     []string{"HOUSEKEEPER_UNPROTECT"},
+		Destination: v,
+	}
+}
+
+// flagContinueOnError pass val to urfave flag.
+func flagContinueOnError(v *bool) *cli.BoolFlag {
+	return &cli.BoolFlag{
+		Name:        "continue-on-error",
+		Usage:       "try to delete every image and report failures at the end instead of stopping at the first one",
+		Value:       false,
+		EnvVars:     []string{"HOUSEKEEPER_CONTINUE_ON_ERROR"},
+		Destination: v,
+	}
+}
+
+// flagParallel pass val to urfave flag.
+func flagParallel(v *int) *cli.IntFlag {
+	return &cli.IntFlag{
+		Name:        "parallel",
+		Usage:       "number of images deleted or updated at the same time",
+		Value:       1,
+		EnvVars:     []string{"HOUSEKEEPER_PARALLEL"},
+		Destination: v,
+	}
+}
@@ -0,0 +1,86 @@
+package action
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/hornwind/openstack-image-keeper/pkg/retention"
+)
+
+// Kinds of --group-by keys.
+const (
+	groupByName       = "name"
+	groupByNameFamily = "name-family"
+	groupByProperty   = "property"
+	groupByTagPrefix  = "tag-prefix"
+)
+
+// imageGroup is a set of images retention is applied to together.
+type imageGroup struct {
+	name   string
+	images []images.Image
+}
+
+// groupKey returns the group of an image, false skips the image.
+type groupKey func(i images.Image) (string, bool)
+
+// parseGroupBy parses "name", "name-family", "property:<key>" or "tag-prefix:<prefix>",
+// name-family is the name without semantic version.
+func parseGroupBy(s string) (groupKey, error) {
+	kind, arg, _ := strings.Cut(s, ":")
+	switch {
+	case s == "" || s == groupByName:
+		return func(i images.Image) (string, bool) {
+			return i.Name, true
+		}, nil
+	case s == groupByNameFamily:
+		return func(i images.Image) (string, bool) {
+			return retention.StripVersion(i.Name), true
+		}, nil
+	case kind == groupByProperty && arg != "":
+		return func(i images.Image) (string, bool) {
+			v, ok := i.Properties[arg]
+			if !ok {
+				return "", false
+			}
+			return fmt.Sprint(v), true
+		}, nil
+	case kind == groupByTagPrefix && arg != "":
+		return func(i images.Image) (string, bool) {
+			for _, tag := range i.Tags {
+				if strings.HasPrefix(tag, arg) && len(tag) > len(arg) {
+					return strings.TrimPrefix(tag, arg), true
+				}
+			}
+			return "", false
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown group-by %q, expected name, name-family, property:<key> or tag-prefix:<prefix>", s)
+	}
+}
+
+// groupImages groups imgs by key, groups are sorted by name and keep the order of images.
+func groupImages(imgs []images.Image, key groupKey) []imageGroup {
+	idx := map[string]int{}
+	groups := []imageGroup{}
+	for _, i := range imgs {
+		name, ok := key(i)
+		if !ok {
+			continue
+		}
+		n, ok := idx[name]
+		if !ok {
+			n = len(groups)
+			idx[name] = n
+			groups = append(groups, imageGroup{name: name})
+		}
+		groups[n].images = append(groups[n].images, i)
+	}
+	sort.SliceStable(groups, func(a, b int) bool {
+		return groups[a].name < groups[b].name
+	})
+
+	return groups
+}
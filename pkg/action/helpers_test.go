@@ -0,0 +1,97 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/hornwind/openstack-image-keeper/pkg/auth"
+	"github.com/hornwind/openstack-image-keeper/pkg/imagestore"
+	"github.com/hornwind/openstack-image-keeper/pkg/usage"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// memoryConnector serves in-memory stores by region name, the default region is "".
+type memoryConnector map[string]*imagestore.Memory
+
+func (m memoryConnector) Regions(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return []string{""}, nil
+	}
+	if slices.Contains(requested, auth.AllRegions) {
+		regions := maps.Keys(m)
+		slices.Sort(regions)
+		return regions, nil
+	}
+
+	return requested, nil
+}
+
+func (m memoryConnector) ImageStore(region string) (imagestore.ImageStore, error) {
+	store, ok := m[region]
+	if !ok {
+		return nil, fmt.Errorf("no endpoint for region %q", region)
+	}
+
+	return store, nil
+}
+
+func (m memoryConnector) Usage(string, bool) (usage.Checker, error) {
+	return usage.Memory{}, nil
+}
+
+// usedConnector serves in-memory stores and images used by servers.
+type usedConnector struct {
+	memoryConnector
+	used usage.Memory
+}
+
+func (u usedConnector) Usage(string, bool) (usage.Checker, error) {
+	return u.used, nil
+}
+
+// failingConnector serves in-memory stores which fail to delete some images.
+type failingConnector struct {
+	memoryConnector
+	fail map[string]error
+}
+
+func (f failingConnector) ImageStore(region string) (imagestore.ImageStore, error) {
+	store, err := f.memoryConnector.ImageStore(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return failingStore{store, f.fail}, nil
+}
+
+// failingStore returns errors of fail on deletion of the images.
+type failingStore struct {
+	imagestore.ImageStore
+	fail map[string]error
+}
+
+func (f failingStore) Delete(ctx context.Context, id string) error {
+	if err, ok := f.fail[id]; ok {
+		return err
+	}
+
+	return f.ImageStore.Delete(ctx, id)
+}
+
+// testContext returns context filled like toCtx does, command output goes to out.
+func testContext(out io.Writer, args ...string) context.Context {
+	c := cli.NewContext(&cli.App{Writer: out}, nil, nil)
+
+	first := ""
+	if len(args) > 0 {
+		first = args[0]
+	}
+
+	ctx := context.WithValue(context.Background(), "cli", c) //nolint:staticcheck // same keys as toCtx
+	ctx = context.WithValue(ctx, "firstArg", first)          //nolint:staticcheck // same
+	ctx = context.WithValue(ctx, "allArgs", args)            //nolint:staticcheck // same
+	return ctx
+}
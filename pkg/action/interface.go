@@ -2,6 +2,8 @@ package action
 
 import (
 	"context"
+	"io"
+	"os"
 
 	log "github.com/hornwind/openstack-image-keeper/pkg/logging"
 	"github.com/urfave/cli/v2"
@@ -38,3 +40,12 @@ func getContextWithFlags(c *cli.Context) context.Context {
 	ctx = context.WithValue(ctx, "allArgs", c.Args().Slice())  //nolint:staticcheck // same
 	return ctx
 }
+
+// outputWriter returns the writer of cli app, it falls back to stdout.
+func outputWriter(ctx context.Context) io.Writer {
+	if c, ok := ctx.Value("cli").(*cli.Context); ok && c.App != nil && c.App.Writer != nil {
+		return c.App.Writer
+	}
+
+	return os.Stdout
+}
@@ -2,12 +2,14 @@ package action
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
 	"text/template"
 
-	"github.com/gophercloud/gophercloud"
-	"github.com/gophercloud/gophercloud/openstack"
 	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/hornwind/openstack-image-keeper/pkg/imagestore"
 	log "github.com/hornwind/openstack-image-keeper/pkg/logging"
 	"github.com/urfave/cli/v2"
 )
@@ -16,7 +18,17 @@ var _ Action = (*List)(nil)
 
 // List is a struct for running 'list' command.
 type List struct {
-	loglevel string
+	conn         connector
+	regions      cli.StringSlice
+	filter       listFilter
+	report       listReport
+	tpl          *template.Template
+	columns      []listColumn
+	loglevel     string
+	templateText string
+	templateFile string
+	format       string
+	columnNames  string
 }
 
 var listOutputTpl string = `Name: {{ .Name }}
@@ -44,54 +56,143 @@ func (l *List) Run(ctx context.Context) error {
 	if err := log.SetLogLevel(l.loglevel); err != nil {
 		return err
 	}
-	ao, err := openstack.AuthOptionsFromEnv()
-	if err != nil {
+
+	if err := l.filter.prepare(); err != nil {
 		return err
 	}
-	provider, err := openstack.AuthenticatedClient(ao)
+
+	var err error
+	l.tpl, err = l.template()
 	if err != nil {
 		return err
 	}
-	listOpts := &images.ListOpts{
-		Owner: os.Getenv("OS_PROJECT_ID"),
+	if err := l.prepareTable(); err != nil {
+		return err
 	}
-	eo := &gophercloud.EndpointOpts{
-		Region: os.Getenv("OS_REGION_NAME"),
+
+	l.conn, err = connect(ctx, l.conn)
+	if err != nil {
+		return err
 	}
 
-	client, _ := openstack.NewImageServiceV2(provider, *eo)
-	allPages, err := images.List(client, listOpts).AllPages()
-	imgs, _ := images.ExtractImages(allPages)
+	l.report = listReport{Images: []imageReport{}}
+	l.report.FailedRegions, err = forEachRegion(ctx, l.conn, l.regions.Value(), l.listRegion)
+	if structured(ctx) {
+		if werr := writeDocument(ctx, l.report); werr != nil {
+			return werr
+		}
+	}
+
+	return err
+}
+
+func (l *List) listRegion(ctx context.Context, store imagestore.ImageStore) error {
+	log := log.GetLogger()
 
+	imgs, err := store.List(ctx, l.filter.listOpts())
 	if err != nil {
 		log.Error(err)
 		return err
 	}
 
-	err = l.ListImages(ctx, imgs)
-	return err
+	return l.ListImages(ctx, l.filter.filter(imgs))
 }
 
+// ListImages renders imgs with the list template, every image is passed to it as images.Image.
 func (l *List) ListImages(ctx context.Context, imgs []images.Image) error {
-	t := template.Must(template.New("Image").Parse(listOutputTpl))
+	if structured(ctx) {
+		for _, i := range imgs {
+			l.report.Images = append(l.report.Images, newImageReport(currentRegion(ctx), i))
+		}
+		return nil
+	}
 
+	if l.format == listFormatTable {
+		return writeTable(outputWriter(ctx), l.columns, imgs)
+	}
+
+	tpl := l.tpl
+	if tpl == nil {
+		tpl = template.Must(newListTemplate().Parse(listOutputTpl))
+	}
+	// the template is parsed once, region is bound to the region being listed
+	t, err := tpl.Clone()
+	if err != nil {
+		return err
+	}
+	t.Funcs(template.FuncMap{
+		"region": func() string {
+			return currentRegion(ctx)
+		},
+	})
 	for _, i := range imgs {
-		val := make(map[string]interface{}, 6)
-		val["Name"] = i.Name
-		val["ID"] = i.ID
-		val["CreatedAt"] = i.CreatedAt
-		val["Protected"] = i.Protected
-		val["Hidden"] = i.Hidden
-		val["Tags"] = i.Tags
-
-		err := t.ExecuteTemplate(os.Stdout, "Image", val)
-		if err != nil {
+		if err := t.Execute(outputWriter(ctx), i); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// template parses --template or --template-file, the default template is used without them.
+func (l *List) template() (*template.Template, error) {
+	text := listOutputTpl
+	switch {
+	case l.templateText != "" && l.templateFile != "":
+		return nil, fmt.Errorf("--template and --template-file can't be used together")
+	case l.templateText != "":
+		text = l.templateText
+	case l.templateFile != "":
+		data, err := os.ReadFile(l.templateFile)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+
+	return newListTemplate().Parse(text)
+}
+
+// prepareTable validates --format and parses --columns of table format.
+func (l *List) prepareTable() error {
+	switch l.format {
+	case "", listFormatBlock:
+		if l.columnNames != "" {
+			return fmt.Errorf("--columns requires --format table")
+		}
+		return nil
+	case listFormatTable:
+		if l.templateText != "" || l.templateFile != "" {
+			return fmt.Errorf("--format table can't be used with a template")
+		}
+	default:
+		return fmt.Errorf("unknown list format %q, expected block or table", l.format)
+	}
+
+	names := l.columnNames
+	if names == "" {
+		names = defaultColumns
+	}
+
+	var err error
+	l.columns, err = parseColumns(names)
+	return err
+}
+
+// newListTemplate returns template with helper functions for list reports,
+// region returns nothing until ListImages binds it.
+func newListTemplate() *template.Template {
+	return template.New("Image").Funcs(template.FuncMap{
+		"join": strings.Join,
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"region": func() string {
+			return ""
+		},
+	})
+}
+
 // Cmd returns 'list' *cli.Command.
 func (l *List) Cmd() *cli.Command {
 	return &cli.Command{
@@ -106,8 +207,16 @@ func (l *List) Cmd() *cli.Command {
 // flags return flag set of CLI urfave.
 func (l *List) flags() []cli.Flag {
 	self := []cli.Flag{
-		flagLogLevel(&l.loglevel),
+		flagRegions(&l.regions),
 	}
+	self = append(self, l.filter.flags()...)
+	self = append(self,
+		flagListFormat(&l.format),
+		flagColumns(&l.columnNames),
+		flagTemplate(&l.templateText),
+		flagTemplateFile(&l.templateFile),
+		flagLogLevel(&l.loglevel),
+	)
 
 	return self
 }
@@ -0,0 +1,122 @@
+package action
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/urfave/cli/v2"
+)
+
+// listFilter selects images for 'list' command. Filters supported by Glance
+// are sent in images.ListOpts, the rest are applied to the result.
+type listFilter struct {
+	name          string
+	nameRegex     string
+	tags          cli.StringSlice
+	properties    cli.StringSlice
+	visibility    string
+	status        string
+	createdBefore time.Time
+	createdAfter  time.Time
+	sort          string
+
+	regex *regexp.Regexp
+	props map[string]string
+}
+
+// prepare validates flags and compiles client-side filters.
+func (f *listFilter) prepare() error {
+	f.regex = nil
+	if f.nameRegex != "" {
+		re, err := regexp.Compile(f.nameRegex)
+		if err != nil {
+			return fmt.Errorf("name-regex: %w", err)
+		}
+		f.regex = re
+	}
+
+	f.props = make(map[string]string, len(f.properties.Value()))
+	for _, p := range f.properties.Value() {
+		key, value, ok := strings.Cut(p, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("property must be key=value, got %q", p)
+		}
+		f.props[key] = value
+	}
+
+	if !f.createdBefore.IsZero() && !f.createdAfter.IsZero() && !f.createdAfter.Before(f.createdBefore) {
+		return fmt.Errorf("created-after must be before created-before")
+	}
+
+	return nil
+}
+
+// listOpts returns filters Glance applies on its side. Glance accepts one
+// created_at filter, created-before is checked by match only.
+func (f *listFilter) listOpts() images.ListOpts {
+	opts := images.ListOpts{
+		Name:       f.name,
+		Tags:       f.tags.Value(),
+		Visibility: images.ImageVisibility(f.visibility),
+		Status:     images.ImageStatus(f.status),
+		Sort:       f.sort,
+	}
+	if !f.createdAfter.IsZero() {
+		opts.CreatedAtQuery = &images.ImageDateQuery{
+			Date:   f.createdAfter.Truncate(time.Second),
+			Filter: images.FilterGTE,
+		}
+	}
+
+	return opts
+}
+
+// match applies filters Glance doesn't support.
+func (f *listFilter) match(i images.Image) bool {
+	switch {
+	case f.regex != nil && !f.regex.MatchString(i.Name):
+		return false
+	case !f.createdAfter.IsZero() && !i.CreatedAt.After(f.createdAfter):
+		return false
+	case !f.createdBefore.IsZero() && !i.CreatedAt.Before(f.createdBefore):
+		return false
+	}
+	for key, value := range f.props {
+		v, ok := i.Properties[key]
+		if !ok || fmt.Sprint(v) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// filter returns imgs matching client-side filters.
+func (f *listFilter) filter(imgs []images.Image) []images.Image {
+	output := make([]images.Image, 0, len(imgs))
+	for _, i := range imgs {
+		if f.match(i) {
+			output = append(output, i)
+		}
+	}
+
+	return output
+}
+
+// flags return list filter flags.
+func (f *listFilter) flags() []cli.Flag {
+	return []cli.Flag{
+		flagName(&f.name),
+		flagNameRegex(&f.nameRegex),
+		flagTag(&f.tags),
+		flagProperty(&f.properties),
+		flagVisibility(&f.visibility),
+		flagStatus(&f.status),
+		flagCreatedBefore(&f.createdBefore),
+		flagCreatedAfter(&f.createdAfter),
+		flagSort(&f.sort),
+	}
+}
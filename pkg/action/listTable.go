@@ -0,0 +1,92 @@
+package action
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+)
+
+// Formats of 'list' text output.
+const (
+	listFormatBlock = "block"
+	listFormatTable = "table"
+)
+
+// defaultColumns are shown by table format without --columns.
+const defaultColumns = "id,name,created,visibility,size,tags"
+
+// listColumn renders one field of an image in the table.
+type listColumn struct {
+	header string
+	value  func(i images.Image) string
+}
+
+var listColumns = map[string]listColumn{
+	"id":         {"ID", func(i images.Image) string { return i.ID }},
+	"name":       {"NAME", func(i images.Image) string { return i.Name }},
+	"created":    {"CREATED", func(i images.Image) string { return i.CreatedAt.UTC().Format(time.RFC3339) }},
+	"updated":    {"UPDATED", func(i images.Image) string { return i.UpdatedAt.UTC().Format(time.RFC3339) }},
+	"visibility": {"VISIBILITY", func(i images.Image) string { return string(i.Visibility) }},
+	"status":     {"STATUS", func(i images.Image) string { return string(i.Status) }},
+	"size":       {"SIZE", func(i images.Image) string { return formatSize(i.SizeBytes) }},
+	"protected":  {"PROTECTED", func(i images.Image) string { return strconv.FormatBool(i.Protected) }},
+	"hidden":     {"HIDDEN", func(i images.Image) string { return strconv.FormatBool(i.Hidden) }},
+	"checksum":   {"CHECKSUM", func(i images.Image) string { return i.Checksum }},
+	"tags":       {"TAGS", func(i images.Image) string { return strings.Join(i.Tags, ",") }},
+}
+
+// parseColumns returns columns by comma separated names.
+func parseColumns(names string) ([]listColumn, error) {
+	output := []listColumn{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		c, ok := listColumns[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q, expected id, name, created, updated, visibility, status, size, protected, hidden, checksum or tags", name)
+		}
+		output = append(output, c)
+	}
+
+	return output, nil
+}
+
+// writeTable writes imgs as aligned columns with a header.
+func writeTable(w io.Writer, columns []listColumn, imgs []images.Image) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	row := make([]string, len(columns))
+	for idx, c := range columns {
+		row[idx] = c.header
+	}
+	fmt.Fprintln(tw, strings.Join(row, "\t"))
+
+	for _, i := range imgs {
+		for idx, c := range columns {
+			row[idx] = c.value(i)
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// formatSize returns size in binary units, e.g. 1.5GiB.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,216 @@
+package action
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/hornwind/openstack-image-keeper/pkg/imagestore"
+	"github.com/stretchr/testify/suite"
+)
+
+type ListSuite struct {
+	suite.Suite
+	list  *List
+	store *imagestore.Memory
+	out   *bytes.Buffer
+}
+
+func TestList(t *testing.T) {
+	suite.Run(t, &ListSuite{})
+}
+
+func (ls *ListSuite) SetupTest() {
+	ls.store = imagestore.NewMemory(images.Image{
+		ID:         "2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11",
+		Name:       "gitlab_dev",
+		Tags:       []string{"master"},
+		Visibility: images.ImageVisibilityPrivate,
+		SizeBytes:  1024,
+		Properties: map[string]interface{}{"os_distro": "ubuntu"},
+		CreatedAt:  time.Now().Add(-time.Hour),
+	}, images.Image{
+		ID:         "8d5c3a56-0a5d-4b8e-a7c0-3f7f1c1b2e22",
+		Name:       "runner",
+		Visibility: images.ImageVisibilityPrivate,
+		Hidden:     true,
+		CreatedAt:  time.Now(),
+	})
+	ls.out = &bytes.Buffer{}
+	ls.list = &List{
+		conn:     memoryConnector{"": ls.store},
+		loglevel: "error",
+	}
+}
+
+func (ls *ListSuite) TestRun() {
+	err := ls.list.Run(testContext(ls.out))
+
+	ls.Require().NoError(err)
+	ls.Assert().Contains(ls.out.String(), "Name: gitlab_dev")
+	ls.Assert().Contains(ls.out.String(), "ID: 2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11")
+	ls.Assert().Contains(ls.out.String(), "  master")
+	ls.Assert().NotContains(ls.out.String(), "runner", "hidden images are not listed by Glance")
+}
+
+func (ls *ListSuite) TestRunRegions() {
+	ls.list.conn = memoryConnector{"ru-1": ls.store, "ru-3": imagestore.NewMemory()}
+	ls.Require().NoError(ls.list.regions.Set("all"))
+
+	err := ls.list.Run(testContext(ls.out))
+
+	ls.Require().NoError(err)
+	ls.Assert().Contains(ls.out.String(), "Region ru-1:\nName: gitlab_dev")
+	ls.Assert().Contains(ls.out.String(), "Region ru-3:\nRegions:\n  ru-1: ok\n  ru-3: ok")
+}
+
+func (ls *ListSuite) TestRunRegionFailed() {
+	ls.Require().NoError(ls.list.regions.Set("ru-1,ru-9"))
+	ls.list.conn = memoryConnector{"ru-1": ls.store}
+
+	err := ls.list.Run(testContext(ls.out))
+
+	ls.Require().EqualError(err, "failed in 1 of 2 regions: ru-9")
+	ls.Assert().Contains(ls.out.String(), "Name: gitlab_dev")
+	ls.Assert().Contains(ls.out.String(), "  ru-9: failed: no endpoint for region \"ru-9\"")
+}
+
+func (ls *ListSuite) TestRunProperties() {
+	err := ls.list.Run(testContext(ls.out))
+
+	ls.Require().NoError(err)
+	ls.Assert().Contains(ls.out.String(), "Properties:\n  os_distro:ubuntu\n")
+}
+
+func (ls *ListSuite) TestRunTemplate() {
+	ls.list.templateText = `{{ .ID }} {{ .Visibility }} {{ .SizeBytes }} {{ join .Tags "," }} {{ json .Properties }}{{ "\n" }}`
+
+	err := ls.list.Run(testContext(ls.out))
+
+	ls.Require().NoError(err)
+	ls.Assert().Equal("2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11 private 1024 master {\"os_distro\":\"ubuntu\"}\n", ls.out.String())
+}
+
+func (ls *ListSuite) TestRunTemplateRegion() {
+	ls.list.conn = memoryConnector{"ru-1": ls.store, "ru-3": imagestore.NewMemory(images.Image{
+		ID:        "4c1d2f3e-5a6b-4c7d-8e9f-0a1b2c3d4e55",
+		CreatedAt: time.Now(),
+	})}
+	ls.Require().NoError(ls.list.regions.Set("all"))
+	ls.list.templateText = `[{{ region }}] {{ .ID }}{{ "\n" }}`
+
+	err := ls.list.Run(testContext(ls.out))
+
+	ls.Require().NoError(err)
+	ls.Assert().Contains(ls.out.String(), "Region ru-1:\n[ru-1] 2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11\n")
+	ls.Assert().Contains(ls.out.String(), "Region ru-3:\n[ru-3] 4c1d2f3e-5a6b-4c7d-8e9f-0a1b2c3d4e55\n")
+}
+
+func (ls *ListSuite) TestRunTemplateFile() {
+	ls.list.templateFile = filepath.Join(ls.T().TempDir(), "list.tpl")
+	ls.Require().NoError(os.WriteFile(ls.list.templateFile, []byte("{{ .Name }};{{ index .Properties \"os_distro\" }}\n"), 0o600))
+
+	err := ls.list.Run(testContext(ls.out))
+
+	ls.Require().NoError(err)
+	ls.Assert().Equal("gitlab_dev;ubuntu\n", ls.out.String())
+}
+
+func (ls *ListSuite) TestRunTemplateErrors() {
+	ls.list.templateText = "{{ .Name }}"
+	ls.list.templateFile = "list.tpl"
+
+	ls.Require().EqualError(ls.list.Run(testContext(ls.out)), "--template and --template-file can't be used together")
+
+	ls.list.templateFile = ""
+	ls.list.templateText = "{{ .Name "
+
+	ls.Require().Error(ls.list.Run(testContext(ls.out)))
+	ls.Assert().Empty(ls.out.String())
+}
+
+func (ls *ListSuite) TestRunFilters() {
+	ls.store.Add(images.Image{
+		ID:         "4c1d2f3e-5a6b-4c7d-8e9f-0a1b2c3d4e55",
+		Name:       "gitlab_prod",
+		Visibility: images.ImageVisibilityPublic,
+		Properties: map[string]interface{}{"os_distro": "debian"},
+		CreatedAt:  time.Now().Add(-48 * time.Hour),
+	})
+
+	for _, tc := range []struct {
+		args func(f *listFilter)
+		want []string
+	}{
+		{func(f *listFilter) { f.name = "gitlab_prod" }, []string{"4c1d2f3e-5a6b-4c7d-8e9f-0a1b2c3d4e55"}},
+		{func(f *listFilter) { f.nameRegex = "^gitlab_" }, []string{"2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11", "4c1d2f3e-5a6b-4c7d-8e9f-0a1b2c3d4e55"}},
+		{func(f *listFilter) { f.tags.Set("master") }, []string{"2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11"}},                 //nolint:errcheck
+		{func(f *listFilter) { f.properties.Set("os_distro=debian") }, []string{"4c1d2f3e-5a6b-4c7d-8e9f-0a1b2c3d4e55"}}, //nolint:errcheck
+		{func(f *listFilter) { f.visibility = "private" }, []string{"2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11"}},
+		{func(f *listFilter) { f.createdBefore = time.Now().Add(-24 * time.Hour) }, []string{"4c1d2f3e-5a6b-4c7d-8e9f-0a1b2c3d4e55"}},
+		{func(f *listFilter) { f.createdAfter = time.Now().Add(-24 * time.Hour) }, []string{"2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11"}},
+		{func(f *listFilter) { f.sort = "created_at:asc" }, []string{"4c1d2f3e-5a6b-4c7d-8e9f-0a1b2c3d4e55", "2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11"}},
+	} {
+		ls.out.Reset()
+		ls.list.filter = listFilter{}
+		ls.list.templateText = "{{ .ID }}\n"
+		tc.args(&ls.list.filter)
+
+		err := ls.list.Run(testContext(ls.out))
+
+		ls.Require().NoError(err)
+		ls.Assert().Equal(strings.Join(tc.want, "\n")+"\n", ls.out.String())
+	}
+}
+
+func (ls *ListSuite) TestRunFilterErrors() {
+	ls.list.filter.nameRegex = "("
+	ls.Require().ErrorContains(ls.list.Run(testContext(ls.out)), "name-regex")
+
+	ls.list.filter = listFilter{}
+	ls.Require().NoError(ls.list.filter.properties.Set("os_distro"))
+	ls.Require().EqualError(ls.list.Run(testContext(ls.out)), `property must be key=value, got "os_distro"`)
+}
+
+func (ls *ListSuite) TestRunTable() {
+	ls.list.format = listFormatTable
+	ls.list.columnNames = "name,id,visibility,size,tags"
+
+	err := ls.list.Run(testContext(ls.out))
+
+	ls.Require().NoError(err)
+	ls.Assert().Equal(
+		"NAME        ID                                    VISIBILITY  SIZE    TAGS\n"+
+			"gitlab_dev  2b0b3d5f-7c4c-4f4e-9d4d-3b2d0d7a1b11  private     1.0KiB  master\n",
+		ls.out.String())
+}
+
+func (ls *ListSuite) TestRunTableErrors() {
+	ls.list.format = listFormatTable
+	ls.list.columnNames = "id,owner"
+	ls.Require().ErrorContains(ls.list.Run(testContext(ls.out)), `unknown column "owner"`)
+
+	ls.list.format = listFormatBlock
+	ls.list.columnNames = "id"
+	ls.Require().EqualError(ls.list.Run(testContext(ls.out)), "--columns requires --format table")
+
+	ls.list.format = "grid"
+	ls.Require().EqualError(ls.list.Run(testContext(ls.out)), `unknown list format "grid", expected block or table`)
+}
+
+func TestFormatSize(t *testing.T) {
+	for size, want := range map[int64]string{
+		0:                      "0B",
+		1023:                   "1023B",
+		1536:                   "1.5KiB",
+		3 * 1024 * 1024 * 1024: "3.0GiB",
+	} {
+		if got := formatSize(size); got != want {
+			t.Errorf("formatSize(%d) = %s, want %s", size, got, want)
+		}
+	}
+}
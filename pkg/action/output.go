@@ -0,0 +1,185 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/hornwind/openstack-image-keeper/pkg/retention"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of the global --output flag.
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+// outputFormat returns the format chosen by the global --output flag.
+func outputFormat(ctx context.Context) string {
+	if c, ok := ctx.Value("cli").(*cli.Context); ok {
+		if f := c.String("output"); f != "" {
+			return f
+		}
+	}
+
+	return outputText
+}
+
+// structured reports if commands render a JSON or YAML document instead of text.
+func structured(ctx context.Context) bool {
+	return outputFormat(ctx) != outputText
+}
+
+// writeDocument encodes v to the command output in the chosen format.
+func writeDocument(ctx context.Context, v interface{}) error {
+	out := outputWriter(ctx)
+
+	switch f := outputFormat(ctx); f {
+	case outputJSON:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputYAML:
+		enc := yaml.NewEncoder(out)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		return fmt.Errorf("unsupported output format %q", f)
+	}
+}
+
+// currentRegion returns the region set by forEachRegion.
+func currentRegion(ctx context.Context) string {
+	region, _ := ctx.Value("region").(string)
+	return region
+}
+
+// imageReport is an image in the list document.
+type imageReport struct {
+	Region     string                 `json:"region" yaml:"region"`
+	ID         string                 `json:"id" yaml:"id"`
+	Name       string                 `json:"name" yaml:"name"`
+	Status     string                 `json:"status" yaml:"status"`
+	Visibility string                 `json:"visibility" yaml:"visibility"`
+	Protected  bool                   `json:"protected" yaml:"protected"`
+	Hidden     bool                   `json:"hidden" yaml:"hidden"`
+	Size       int64                  `json:"size" yaml:"size"`
+	Checksum   string                 `json:"checksum" yaml:"checksum"`
+	CreatedAt  time.Time              `json:"created_at" yaml:"created_at"`
+	UpdatedAt  time.Time              `json:"updated_at" yaml:"updated_at"`
+	Tags       []string               `json:"tags" yaml:"tags"`
+	Properties map[string]interface{} `json:"properties" yaml:"properties"`
+}
+
+func newImageReport(region string, i images.Image) imageReport {
+	tags := i.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	properties := i.Properties
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
+
+	return imageReport{
+		Region:     region,
+		ID:         i.ID,
+		Name:       i.Name,
+		Status:     string(i.Status),
+		Visibility: string(i.Visibility),
+		Protected:  i.Protected,
+		Hidden:     i.Hidden,
+		Size:       i.SizeBytes,
+		Checksum:   i.Checksum,
+		CreatedAt:  i.CreatedAt,
+		UpdatedAt:  i.UpdatedAt,
+		Tags:       tags,
+		Properties: properties,
+	}
+}
+
+// listReport is the document of 'list' command.
+type listReport struct {
+	Images        []imageReport   `json:"images" yaml:"images"`
+	FailedRegions []regionFailure `json:"failed_regions" yaml:"failed_regions"`
+}
+
+// decisionReport is an image kept or deleted by cleanup with the reason.
+type decisionReport struct {
+	Region    string    `json:"region" yaml:"region"`
+	ID        string    `json:"id" yaml:"id"`
+	Name      string    `json:"name" yaml:"name"`
+	CreatedAt time.Time `json:"created_at" yaml:"created_at"`
+	Tags      []string  `json:"tags" yaml:"tags"`
+	Rule      string    `json:"rule" yaml:"rule"`
+	Reason    string    `json:"reason" yaml:"reason"`
+}
+
+func newDecisionReport(region string, d retention.Decision) decisionReport {
+	tags := d.Image.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return decisionReport{
+		Region:    region,
+		ID:        d.Image.ID,
+		Name:      d.Image.Name,
+		CreatedAt: d.Image.CreatedAt,
+		Tags:      tags,
+		Rule:      d.Rule,
+		Reason:    d.Reason,
+	}
+}
+
+// groupReport counts images kept and deleted in a group of cleanup.
+type groupReport struct {
+	Region  string `json:"region" yaml:"region"`
+	Name    string `json:"name" yaml:"name"`
+	Kept    int    `json:"kept" yaml:"kept"`
+	Deleted int    `json:"deleted" yaml:"deleted"`
+}
+
+// cleanupReport is the document of 'cleanup' command, name is set
+// when cleanup ran for a single image name.
+type cleanupReport struct {
+	Name          string           `json:"name" yaml:"name"`
+	NameRegex     string           `json:"name_regex,omitempty" yaml:"name_regex,omitempty"`
+	DryRun        bool             `json:"dry_run" yaml:"dry_run"`
+	Groups        []groupReport    `json:"groups" yaml:"groups"`
+	Kept          []decisionReport `json:"kept" yaml:"kept"`
+	Deleted       []decisionReport `json:"deleted" yaml:"deleted"`
+	Failed        []deleteFailure  `json:"failed" yaml:"failed"`
+	FailedRegions []regionFailure  `json:"failed_regions" yaml:"failed_regions"`
+}
+
+// imageRef identifies an image in a region.
+type imageRef struct {
+	Region string `json:"region" yaml:"region"`
+	ID     string `json:"id" yaml:"id"`
+	Name   string `json:"name" yaml:"name"`
+}
+
+// deleteReport is the document of 'delete' command, skipped are images not
+// attempted after the first failure without --continue-on-error.
+type deleteReport struct {
+	Deleted []string        `json:"deleted" yaml:"deleted"`
+	Failed  []deleteFailure `json:"failed" yaml:"failed"`
+	Skipped []string        `json:"skipped" yaml:"skipped"`
+}
+
+// publishReport is the document of 'publish' command.
+type publishReport struct {
+	DryRun        bool            `json:"dry_run" yaml:"dry_run"`
+	Published     []imageRef      `json:"published" yaml:"published"`
+	Unpublished   []imageRef      `json:"unpublished" yaml:"unpublished"`
+	FailedRegions []regionFailure `json:"failed_regions" yaml:"failed_regions"`
+}
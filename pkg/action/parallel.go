@@ -0,0 +1,56 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// errNotStarted is returned by parallel for calls skipped after a failure.
+var errNotStarted = errors.New("not started")
+
+// parallel calls fn for indexes from 0 to count-1 in at most n goroutines,
+// n below 1 runs calls one by one. Errors are returned by index, so callers
+// report results in a stable order whatever order calls finish in.
+// With stopOnError the first failure prevents calls which haven't started
+// yet, they get errNotStarted. Calls not started before ctx is done get
+// the error of ctx.
+func parallel(ctx context.Context, n, count int, stopOnError bool, fn func(ctx context.Context, idx int) error) []error {
+	if n < 1 {
+		n = 1
+	}
+
+	errs := make([]error, count)
+	sem := make(chan struct{}, n)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for idx := 0; idx < count; idx++ {
+		sem <- struct{}{}
+		if failed.Load() {
+			errs[idx] = errNotStarted
+			<-sem
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			errs[idx] = err
+			<-sem
+			continue
+		}
+
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(ctx, idx); err != nil {
+				errs[idx] = err
+				if stopOnError {
+					failed.Store(true)
+				}
+			}
+		}(idx)
+	}
+	wg.Wait()
+
+	return errs
+}
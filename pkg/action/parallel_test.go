@@ -0,0 +1,64 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParallel(t *testing.T) {
+	var running, peak atomic.Int32
+	errs := parallel(context.Background(), 3, 10, false, func(_ context.Context, idx int) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		// later calls finish first, errors still come back by index
+		time.Sleep(time.Duration(10-idx) * time.Millisecond)
+		if idx%4 == 0 {
+			return errors.New("fail")
+		}
+		return nil
+	})
+
+	assert.LessOrEqual(t, peak.Load(), int32(3))
+	assert.Len(t, errs, 10)
+	for idx, err := range errs {
+		if idx%4 == 0 {
+			assert.EqualError(t, err, "fail", idx)
+		} else {
+			assert.NoError(t, err, idx)
+		}
+	}
+}
+
+func TestParallelStopOnError(t *testing.T) {
+	errs := parallel(context.Background(), 1, 3, true, func(_ context.Context, idx int) error {
+		if idx == 1 {
+			return errors.New("fail")
+		}
+		return nil
+	})
+
+	assert.Equal(t, []error{nil, errors.New("fail"), errNotStarted}, errs)
+}
+
+func TestParallelCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := parallel(ctx, 0, 3, false, func(_ context.Context, idx int) error {
+		if idx == 0 {
+			cancel()
+		}
+		return nil
+	})
+
+	assert.Equal(t, []error{nil, context.Canceled, context.Canceled}, errs)
+}
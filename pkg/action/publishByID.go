@@ -3,25 +3,24 @@ package action
 import (
 	"context"
 	"fmt"
-	"os"
 	"text/template"
 
-	"github.com/gophercloud/gophercloud"
-	"github.com/gophercloud/gophercloud/openstack"
 	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/hornwind/openstack-image-keeper/pkg/imagestore"
 	log "github.com/hornwind/openstack-image-keeper/pkg/logging"
 	"github.com/urfave/cli/v2"
 )
 
 type Publication struct {
-	authOpts  *gophercloud.AuthOptions
-	provider  *gophercloud.ProviderClient
-	client    *gophercloud.ServiceClient
-	eo        *gophercloud.EndpointOpts
+	conn      connector
+	regions   cli.StringSlice
+	store     imagestore.ImageStore
+	report    publishReport
 	loglevel  string
 	dryRun    bool
 	protected bool
 	hidden    bool
+	parallel  int
 }
 
 var (
@@ -43,9 +42,6 @@ func (p *Publication) Run(ctx context.Context) error {
 	if err := log.SetLogLevel(p.loglevel); err != nil {
 		return err
 	}
-	if err := p.configureClient(); err != nil {
-		return err
-	}
 
 	imgUUID, ok := ctx.Value("firstArg").(string)
 	if !ok {
@@ -54,32 +50,83 @@ func (p *Publication) Run(ctx context.Context) error {
 		return err
 	}
 
-	imagesWithSameName, err := p.getImagesWithSameName(imgUUID)
+	if p.parallel < 0 {
+		return fmt.Errorf("parallel must not be negative, got %d", p.parallel)
+	}
+
+	var err error
+	p.conn, err = connect(ctx, p.conn)
 	if err != nil {
 		return err
 	}
 
+	p.report = publishReport{
+		DryRun:      p.dryRun,
+		Published:   []imageRef{},
+		Unpublished: []imageRef{},
+	}
+	name := p.imageName(ctx, imgUUID)
+	p.report.FailedRegions, err = forEachRegion(ctx, p.conn, p.regions.Value(), func(ctx context.Context, store imagestore.ImageStore) error {
+		p.store = store
+		return p.publishImage(ctx, imgUUID, name)
+	})
+	if structured(ctx) {
+		if werr := writeDocument(ctx, p.report); werr != nil {
+			return werr
+		}
+	}
+
+	return err
+}
+
+func (p *Publication) publishImage(ctx context.Context, uuid, name string) error {
+	log := log.GetLogger()
+	if name == "" {
+		return fmt.Errorf("image %s not found", uuid)
+	}
+
+	imagesWithSameName, err := p.store.List(ctx, images.ListOpts{Name: name})
+	if err != nil {
+		return err
+	}
+
+	imgUUID, err := regionCopy(uuid, name, imagesWithSameName)
+	if err != nil {
+		return err
+	}
+	if imgUUID != uuid {
+		log.Infof("Publishing %s, the copy of image %s in region %s", imgUUID, uuid, currentRegion(ctx))
+	}
+
 	if p.dryRun {
-		return p.dryRunAnnounce(imgUUID, imagesWithSameName)
+		return p.dryRunAnnounce(ctx, imgUUID, imagesWithSameName)
 	}
 
-	if err := p.updateImagesWithSameName(imagesWithSameName, images.ImageVisibilityPrivate, false, false); err != nil {
+	if err := p.updateImagesWithSameName(ctx, imagesWithSameName, images.ImageVisibilityPrivate, false, false); err != nil {
 		return err
 	}
-	if err := p.setProtected(imgUUID, p.protected); err != nil {
+	if err := p.setProtected(ctx, imgUUID, p.protected); err != nil {
 		return err
 	}
-	if err := p.setVisibility(imgUUID, images.ImageVisibilityPublic); err != nil {
+	if err := p.setVisibility(ctx, imgUUID, images.ImageVisibilityPublic); err != nil {
 		return err
 	}
-	if err := p.setHidden(imgUUID, p.hidden); err != nil {
+	if err := p.setHidden(ctx, imgUUID, p.hidden); err != nil {
 		return err
 	}
 
+	for _, i := range imagesWithSameName {
+		if i.ID == imgUUID {
+			p.report.Published = append(p.report.Published, imageRef{currentRegion(ctx), i.ID, i.Name})
+			continue
+		}
+		p.report.Unpublished = append(p.report.Unpublished, imageRef{currentRegion(ctx), i.ID, i.Name})
+	}
+
 	return nil
 }
 
-func (p *Publication) dryRunAnnounce(uuid string, imagesWithSameName []images.Image) error {
+func (p *Publication) dryRunAnnounce(ctx context.Context, uuid string, imagesWithSameName []images.Image) error {
 	imgForUnpublish := []images.Image{}
 	for _, img := range imagesWithSameName {
 		i := img
@@ -91,117 +138,127 @@ func (p *Publication) dryRunAnnounce(uuid string, imagesWithSameName []images.Im
 		}
 	}
 
-	imgForPublication, err := p.getImagesByUUID(uuid)
+	imgForPublication, err := p.getImagesByUUID(ctx, uuid)
 	if err != nil {
 		return err
 	}
 
+	if structured(ctx) {
+		for _, i := range imgForPublication {
+			p.report.Published = append(p.report.Published, imageRef{currentRegion(ctx), i.ID, i.Name})
+		}
+		for _, i := range imgForUnpublish {
+			p.report.Unpublished = append(p.report.Unpublished, imageRef{currentRegion(ctx), i.ID, i.Name})
+		}
+		return nil
+	}
+
 	val := make(map[string]interface{}, 6)
 	val["imgForPublication"] = imgForPublication
 	val["imagesForUnpublish"] = imgForUnpublish
-	template.Must(template.New("Output").Parse(tplPublishOutput)).Execute(os.Stdout, val) //nolint:errcheck
+	template.Must(template.New("Output").Parse(tplPublishOutput)).Execute(outputWriter(ctx), val) //nolint:errcheck
 
 	return nil
 }
 
-func (p *Publication) getImagesByUUID(uuid string) ([]images.Image, error) {
-	targetImageListOpts := &images.ListOpts{
-		Owner: os.Getenv("OS_PROJECT_ID"),
-		ID:    uuid,
+func (p *Publication) getImagesByUUID(ctx context.Context, uuid string) ([]images.Image, error) {
+	targetImageListOpts := images.ListOpts{
+		ID: uuid,
 	}
 
-	allPages, err := images.List(p.client, targetImageListOpts).AllPages()
-	if err != nil {
-		return nil, err
-	}
-	imgs, err := images.ExtractImages(allPages)
-	if err != nil {
-		return nil, err
-	}
-	return imgs, nil
+	return p.store.List(ctx, targetImageListOpts)
 }
 
-func (p *Publication) getImagesWithSameName(uuid string) ([]images.Image, error) {
-	imgs, err := p.getImagesByUUID(uuid)
+// imageName returns the name of image uuid in the first requested region
+// which has it, empty if no region has it. Image IDs differ between regions,
+// the copies of the image in other regions are found by its name.
+func (p *Publication) imageName(ctx context.Context, uuid string) string {
+	log := log.GetLogger()
+
+	regions, err := p.conn.Regions(p.regions.Value())
 	if err != nil {
-		return nil, err
+		return ""
 	}
-
-	imagesByNameOpts := &images.ListOpts{
-		Owner: os.Getenv("OS_PROJECT_ID"),
-		Name:  imgs[0].Name,
+	for _, region := range regions {
+		var imgs []images.Image
+		err := runInRegion(ctx, p.conn, region, func(ctx context.Context, store imagestore.ImageStore) (err error) {
+			imgs, err = store.List(ctx, images.ListOpts{ID: uuid})
+			return err
+		})
+		if err != nil {
+			log.GetLoggerWithField("region", region).Debugf("looking up image %s: %s", uuid, err)
+			continue
+		}
+		if len(imgs) > 0 {
+			return imgs[0].Name
+		}
 	}
 
-	allImagesWithSameName, err := images.List(p.client, imagesByNameOpts).AllPages()
-	if err != nil {
-		return nil, err
+	return ""
+}
+
+// regionCopy returns the image to publish among imgs named name in a region:
+// uuid itself if the region has it, otherwise the newest image with its name.
+func regionCopy(uuid, name string, imgs []images.Image) (string, error) {
+	var newest *images.Image
+	for i := range imgs {
+		if imgs[i].ID == uuid {
+			return uuid, nil
+		}
+		if newest == nil || imgs[i].CreatedAt.After(newest.CreatedAt) {
+			newest = &imgs[i]
+		}
 	}
-	imagesWithSameName, err := images.ExtractImages(allImagesWithSameName)
-	if err != nil {
-		return nil, err
+	if newest == nil {
+		return "", fmt.Errorf("image %s not found, no image named %s", uuid, name)
 	}
 
-	return imagesWithSameName, nil
+	return newest.ID, nil
 }
 
-func (p *Publication) setVisibility(id string, visibility images.ImageVisibility) error {
-	image := images.Update(p.client, id, images.UpdateOpts{
+func (p *Publication) setVisibility(ctx context.Context, id string, visibility images.ImageVisibility) error {
+	_, err := p.store.Update(ctx, id, images.UpdateOpts{
 		images.UpdateVisibility{Visibility: visibility},
 	})
-	return image.Err
+	return err
 }
 
-func (p *Publication) setHidden(id string, hidden bool) error {
-	image := images.Update(p.client, id, images.UpdateOpts{
+func (p *Publication) setHidden(ctx context.Context, id string, hidden bool) error {
+	_, err := p.store.Update(ctx, id, images.UpdateOpts{
 		images.ReplaceImageHidden{NewHidden: hidden},
 	})
-	return image.Err
+	return err
 }
 
-func (p *Publication) setProtected(id string, protected bool) error {
-	image := images.Update(p.client, id, images.UpdateOpts{
+func (p *Publication) setProtected(ctx context.Context, id string, protected bool) error {
+	_, err := p.store.Update(ctx, id, images.UpdateOpts{
 		images.ReplaceImageProtected{NewProtected: protected},
 	})
-	return image.Err
+	return err
 }
 
-func (p *Publication) updateImagesWithSameName(imageList []images.Image, visibility images.ImageVisibility, protected, hidden bool) error {
-	for _, image := range imageList {
-		i := image
+// updateImagesWithSameName updates --parallel images at once and returns
+// the error of the first failed image in the order of imageList.
+func (p *Publication) updateImagesWithSameName(ctx context.Context, imageList []images.Image, visibility images.ImageVisibility, protected, hidden bool) error {
+	errs := parallel(ctx, p.parallel, len(imageList), true, func(ctx context.Context, idx int) error {
+		i := imageList[idx]
 
-		if err := p.setProtected(i.ID, protected); err != nil {
+		if err := p.setProtected(ctx, i.ID, protected); err != nil {
 			return err
 		}
-		if err := p.setVisibility(i.ID, visibility); err != nil {
+		if err := p.setVisibility(ctx, i.ID, visibility); err != nil {
 			return err
 		}
-		if err := p.setHidden(i.ID, hidden); err != nil {
+		return p.setHidden(ctx, i.ID, hidden)
+	})
+	for _, err := range errs {
+		if err != nil && err != errNotStarted {
 			return err
 		}
 	}
 	return nil
 }
 
-func (p *Publication) configureClient() error {
-	ao, err := openstack.AuthOptionsFromEnv()
-	if err != nil {
-		return err
-	}
-	p.authOpts = &ao
-	p.provider, err = openstack.AuthenticatedClient(*p.authOpts)
-	if err != nil {
-		return err
-	}
-	p.eo = &gophercloud.EndpointOpts{
-		Region: os.Getenv("OS_REGION_NAME"),
-	}
-	p.client, err = openstack.NewImageServiceV2(p.provider, *p.eo)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 // function Cmd
 func (p *Publication) Cmd() *cli.Command {
 	return &cli.Command{
@@ -215,9 +272,11 @@ func (p *Publication) Cmd() *cli.Command {
 // flags return flag set of CLI urfave.
 func (p *Publication) flags() []cli.Flag {
 	self := []cli.Flag{
+		flagRegions(&p.regions),
 		flagDryRun(&p.dryRun),
 		flagProtected(&p.protected),
 		flagHidden(&p.hidden),
+		flagParallel(&p.parallel),
 		flagLogLevel(&p.loglevel),
 	}
 
@@ -0,0 +1,104 @@
+package action
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/hornwind/openstack-image-keeper/pkg/imagestore"
+	"github.com/stretchr/testify/suite"
+)
+
+type PublicationSuite struct {
+	suite.Suite
+	publication *Publication
+	store       *imagestore.Memory
+	out         *bytes.Buffer
+}
+
+func TestPublication(t *testing.T) {
+	suite.Run(t, &PublicationSuite{})
+}
+
+func (ps *PublicationSuite) SetupTest() {
+	ps.store = imagestore.NewMemory(images.Image{
+		ID:         "e6637019-e80c-49b1-84ff-1bbe97cfcd64",
+		Name:       "gitlab_dev",
+		Visibility: images.ImageVisibilityPrivate,
+		CreatedAt:  time.Now(),
+	}, images.Image{
+		ID:         "5beb9780-8eed-480f-807f-7a99c89174f2",
+		Name:       "gitlab_dev",
+		Visibility: images.ImageVisibilityPublic,
+		Protected:  true,
+		CreatedAt:  time.Now().Add(-time.Hour),
+	}, images.Image{
+		ID:         "cf03fca9-e36b-4494-b8df-694d4cc4d319",
+		Name:       "runner",
+		Visibility: images.ImageVisibilityPublic,
+		CreatedAt:  time.Now().Add(-time.Hour),
+	})
+	ps.out = &bytes.Buffer{}
+	ps.publication = &Publication{
+		conn:     memoryConnector{"": ps.store},
+		loglevel: "error",
+	}
+}
+
+func (ps *PublicationSuite) image(id string) images.Image {
+	i, err := ps.store.Get(testContext(nil), id)
+	ps.Require().NoError(err)
+	return *i
+}
+
+func (ps *PublicationSuite) TestRun() {
+	ps.publication.protected = true
+
+	err := ps.publication.Run(testContext(ps.out, "e6637019-e80c-49b1-84ff-1bbe97cfcd64"))
+
+	ps.Require().NoError(err)
+	published := ps.image("e6637019-e80c-49b1-84ff-1bbe97cfcd64")
+	ps.Assert().Equal(images.ImageVisibilityPublic, published.Visibility)
+	ps.Assert().True(published.Protected)
+
+	previous := ps.image("5beb9780-8eed-480f-807f-7a99c89174f2")
+	ps.Assert().Equal(images.ImageVisibilityPrivate, previous.Visibility)
+	ps.Assert().False(previous.Protected)
+
+	ps.Assert().Equal(images.ImageVisibilityPublic, ps.image("cf03fca9-e36b-4494-b8df-694d4cc4d319").Visibility)
+}
+
+func (ps *PublicationSuite) TestRunParallel() {
+	ps.store.Add(images.Image{
+		ID:         "0d5c6f2e-9b8a-4f57-a1c3-2e6d8b4f9a10",
+		Name:       "gitlab_dev",
+		Visibility: images.ImageVisibilityPublic,
+		CreatedAt:  time.Now().Add(-2 * time.Hour),
+	})
+	ps.publication.parallel = 4
+
+	err := ps.publication.Run(testContext(ps.out, "e6637019-e80c-49b1-84ff-1bbe97cfcd64"))
+
+	ps.Require().NoError(err)
+	ps.Assert().Equal(images.ImageVisibilityPublic, ps.image("e6637019-e80c-49b1-84ff-1bbe97cfcd64").Visibility)
+	ps.Assert().Equal(images.ImageVisibilityPrivate, ps.image("5beb9780-8eed-480f-807f-7a99c89174f2").Visibility)
+	ps.Assert().Equal(images.ImageVisibilityPrivate, ps.image("0d5c6f2e-9b8a-4f57-a1c3-2e6d8b4f9a10").Visibility)
+}
+
+func (ps *PublicationSuite) TestRunDryRun() {
+	ps.publication.dryRun = true
+
+	err := ps.publication.Run(testContext(ps.out, "e6637019-e80c-49b1-84ff-1bbe97cfcd64"))
+
+	ps.Require().NoError(err)
+	ps.Assert().Equal(images.ImageVisibilityPrivate, ps.image("e6637019-e80c-49b1-84ff-1bbe97cfcd64").Visibility)
+	ps.Assert().Contains(ps.out.String(), "These images will be published:\n  e6637019-e80c-49b1-84ff-1bbe97cfcd64\n")
+	ps.Assert().Contains(ps.out.String(), "These images will be private:\n  5beb9780-8eed-480f-807f-7a99c89174f2\n")
+}
+
+func (ps *PublicationSuite) TestRunNotFound() {
+	err := ps.publication.Run(testContext(ps.out, "00000000-0000-0000-0000-000000000000"))
+
+	ps.Require().EqualError(err, "image 00000000-0000-0000-0000-000000000000 not found")
+}
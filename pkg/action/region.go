@@ -0,0 +1,88 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hornwind/openstack-image-keeper/pkg/imagestore"
+	log "github.com/hornwind/openstack-image-keeper/pkg/logging"
+	"github.com/urfave/cli/v2"
+)
+
+// regionFunc runs a command in one region.
+type regionFunc func(ctx context.Context, store imagestore.ImageStore) error
+
+// regionFailure is a region a command failed in.
+type regionFailure struct {
+	Region string `json:"region" yaml:"region"`
+	Error  string `json:"error" yaml:"error"`
+}
+
+// forEachRegion runs fn in every requested region, the region name is
+// available to fn by currentRegion. With several regions text output is
+// prefixed by the region name, a summary is printed at the end and an error
+// is returned if any region failed. Failed regions are returned for documents.
+func forEachRegion(ctx context.Context, conn connector, requested []string, fn regionFunc) ([]regionFailure, error) {
+	log := log.GetLogger()
+	out := outputWriter(ctx)
+	failures := []regionFailure{}
+
+	regions, err := conn.Regions(requested)
+	if err != nil {
+		return failures, err
+	}
+	if len(regions) == 1 {
+		if err := runInRegion(ctx, conn, regions[0], fn); err != nil {
+			return append(failures, regionFailure{regions[0], err.Error()}), err
+		}
+		return failures, nil
+	}
+
+	results := make([]string, 0, len(regions))
+	failed := []string{}
+	for _, region := range regions {
+		if !structured(ctx) {
+			fmt.Fprintf(out, "Region %s:\n", region)
+		}
+
+		if err := runInRegion(ctx, conn, region, fn); err != nil {
+			log.GetLoggerWithField("region", region).Error(err)
+			failed = append(failed, region)
+			failures = append(failures, regionFailure{region, err.Error()})
+			results = append(results, fmt.Sprintf("  %s: failed: %s", region, err))
+			continue
+		}
+		results = append(results, fmt.Sprintf("  %s: ok", region))
+	}
+
+	if !structured(ctx) {
+		fmt.Fprintf(out, "Regions:\n%s\n", strings.Join(results, "\n"))
+	}
+	if len(failed) > 0 {
+		return failures, fmt.Errorf("failed in %d of %d regions: %s", len(failed), len(regions), strings.Join(failed, ", "))
+	}
+
+	return failures, nil
+}
+
+func runInRegion(ctx context.Context, conn connector, region string, fn regionFunc) error {
+	store, err := conn.ImageStore(region)
+	if err != nil {
+		return err
+	}
+	store = withRetries(ctx, store)
+
+	return fn(context.WithValue(ctx, "region", region), store) //nolint:staticcheck // same keys as toCtx
+}
+
+// withRetries wraps store to repeat calls failed with transient errors as
+// the global --retries and --retry-backoff flags ask.
+func withRetries(ctx context.Context, store imagestore.ImageStore) imagestore.ImageStore {
+	c, ok := ctx.Value("cli").(*cli.Context)
+	if !ok || c.Int("retries") < 1 {
+		return store
+	}
+
+	return imagestore.NewRetry(store, c.Int("retries"), c.Duration("retry-backoff"))
+}
@@ -0,0 +1,70 @@
+package action
+
+import (
+	"context"
+
+	"github.com/hornwind/openstack-image-keeper/pkg/auth"
+	"github.com/hornwind/openstack-image-keeper/pkg/imagestore"
+	"github.com/hornwind/openstack-image-keeper/pkg/usage"
+	"github.com/urfave/cli/v2"
+)
+
+// connector opens image stores for the regions a command runs in.
+type connector interface {
+	Regions(requested []string) ([]string, error)
+	ImageStore(region string) (imagestore.ImageStore, error)
+	// Usage returns checker of images used by servers and, if volumes is set, by volumes.
+	Usage(region string, volumes bool) (usage.Checker, error)
+}
+
+// sessionConnector opens Glance backed stores with an authenticated session.
+type sessionConnector struct {
+	*auth.Session
+}
+
+// ImageStore returns Glance store of the session project in region.
+func (s sessionConnector) ImageStore(region string) (imagestore.ImageStore, error) {
+	client, err := s.ImageClient(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return imagestore.NewGlance(client, s.ProjectID()), nil
+}
+
+// Usage returns Nova backed checker of the session project in region.
+func (s sessionConnector) Usage(region string, volumes bool) (usage.Checker, error) {
+	compute, err := s.ComputeClient(region)
+	if err != nil {
+		return nil, err
+	}
+	if !volumes {
+		return usage.NewCompute(compute, nil), nil
+	}
+
+	volume, err := s.VolumeClient(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return usage.NewCompute(compute, volume), nil
+}
+
+// connect returns conn if it was injected, otherwise it authenticates against
+// the cloud chosen by the global --os-cloud flag.
+func connect(ctx context.Context, conn connector) (connector, error) {
+	if conn != nil {
+		return conn, nil
+	}
+
+	cloud := ""
+	if c, ok := ctx.Value("cli").(*cli.Context); ok {
+		cloud = c.String("os-cloud")
+	}
+	session, err := auth.NewSession(cloud)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessionConnector{session}, nil
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"errors"
+	"os"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/gophercloud/utils/openstack/clientconfig"
+	log "github.com/hornwind/openstack-image-keeper/pkg/logging"
+	"golang.org/x/exp/slices"
+)
+
+// AllRegions requests every region with an image endpoint in the service catalog.
+const AllRegions = "all"
+
+// Session is an authenticated connection to an OpenStack cloud.
+type Session struct {
+	provider  *gophercloud.ProviderClient
+	region    string
+	projectID string
+}
+
+// NewSession authenticates against the named cloud from clouds.yaml and secure.yaml.
+// An empty name falls back to OS_CLOUD and then to the OS_* environment variables.
+func NewSession(cloud string) (*Session, error) {
+	log := log.GetLogger()
+
+	clientOpts := &clientconfig.ClientOpts{
+		Cloud: cloud,
+	}
+	ao, err := clientconfig.AuthOptions(clientOpts)
+	if err != nil {
+		log.Debug(err)
+		return nil, err
+	}
+	ao.AllowReauth = true
+
+	s := &Session{
+		region:    os.Getenv("OS_REGION_NAME"),
+		projectID: ao.TenantID,
+	}
+
+	if cloud != "" || os.Getenv("OS_CLOUD") != "" {
+		c, err := clientconfig.GetCloudFromYAML(clientOpts)
+		if err != nil {
+			log.Debug(err)
+			return nil, err
+		}
+		if c.RegionName != "" {
+			s.region = c.RegionName
+		}
+		if c.AuthInfo != nil && c.AuthInfo.ProjectID != "" {
+			s.projectID = c.AuthInfo.ProjectID
+		}
+	}
+
+	s.provider, err = openstack.AuthenticatedClient(*ao)
+	if err != nil {
+		log.Debug(err)
+		return nil, err
+	}
+
+	if s.projectID == "" {
+		s.projectID = s.projectFromToken()
+	}
+	log.Debugf("authenticated in region %q, project %q", s.region, s.projectID)
+
+	return s, nil
+}
+
+// Region returns the default region of the session.
+func (s *Session) Region() string {
+	return s.region
+}
+
+// ProjectID returns the id of the project the session is scoped to.
+func (s *Session) ProjectID() string {
+	return s.projectID
+}
+
+// ImageClient returns a Glance v2 client for region, an empty region means the default one.
+func (s *Session) ImageClient(region string) (*gophercloud.ServiceClient, error) {
+	return openstack.NewImageServiceV2(s.provider, s.endpointOpts(region))
+}
+
+// ComputeClient returns a Nova v2 client for region, an empty region means the default one.
+func (s *Session) ComputeClient(region string) (*gophercloud.ServiceClient, error) {
+	return openstack.NewComputeV2(s.provider, s.endpointOpts(region))
+}
+
+// VolumeClient returns a Cinder v3 client for region, an empty region means the default one.
+func (s *Session) VolumeClient(region string) (*gophercloud.ServiceClient, error) {
+	return openstack.NewBlockStorageV3(s.provider, s.endpointOpts(region))
+}
+
+// Regions resolves the requested region names. No names mean the default region,
+// AllRegions expands to the regions of the image service in the catalog.
+func (s *Session) Regions(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return []string{s.region}, nil
+	}
+	if !slices.Contains(requested, AllRegions) {
+		return requested, nil
+	}
+
+	result, ok := s.provider.GetAuthResult().(tokens.CreateResult)
+	if !ok {
+		return nil, errors.New("region discovery requires identity v3 service catalog")
+	}
+	catalog, err := result.ExtractServiceCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	regions := []string{}
+	for _, entry := range catalog.Entries {
+		if entry.Type != "image" {
+			continue
+		}
+		for _, endpoint := range entry.Endpoints {
+			region := endpoint.Region
+			if region == "" {
+				region = endpoint.RegionID
+			}
+			if region != "" && !slices.Contains(regions, region) {
+				regions = append(regions, region)
+			}
+		}
+	}
+	if len(regions) == 0 {
+		return nil, errors.New("no image service regions found in catalog")
+	}
+	slices.Sort(regions)
+
+	return regions, nil
+}
+
+func (s *Session) endpointOpts(region string) gophercloud.EndpointOpts {
+	if region == "" {
+		region = s.region
+	}
+
+	return gophercloud.EndpointOpts{
+		Region: region,
+	}
+}
+
+// projectFromToken returns the project the token is scoped to, it is used
+// when only the project name is configured.
+func (s *Session) projectFromToken() string {
+	result, ok := s.provider.GetAuthResult().(tokens.CreateResult)
+	if !ok {
+		return ""
+	}
+	project, err := result.ExtractProject()
+	if err != nil || project == nil {
+		return ""
+	}
+
+	return project.ID
+}
@@ -0,0 +1,507 @@
+package fakecloud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/hornwind/openstack-image-keeper/pkg/imagestore"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// ProjectID is the id of the project tokens are scoped to.
+	ProjectID = "b2c6d1a4e7f84d3c9a0b5e6f7a8b9c0d"
+	// Token is the token issued by the fake Keystone.
+	Token = "fake-token"
+
+	username = "housekeeper"
+	password = "secret"
+)
+
+// Server is a fake OpenStack cloud serving the Keystone v3 token endpoint
+// and the subset of Glance v2, Nova v2.1 and Cinder v3 used by housekeeper.
+// Images of every region are kept in an in-memory store.
+type Server struct {
+	*httptest.Server
+	// PageSize limits images per page when a request has no limit, like api_limit_max of Glance.
+	PageSize int
+	regions  []string
+	stores   map[string]*imagestore.Memory
+	mu       sync.Mutex
+	servers  map[string][]resource
+	volumes  map[string][]resource
+	failures []failure
+}
+
+// failure is a response returned instead of serving an image request.
+type failure struct {
+	status int
+	header http.Header
+}
+
+// resource is a server or a volume created from an image.
+type resource struct {
+	ID      string
+	Name    string
+	ImageID string
+}
+
+// NewServer starts a fake cloud with image endpoints in regions,
+// the first region is the default one. It is closed with the test.
+func NewServer(t testing.TB, regions ...string) *Server {
+	t.Helper()
+	if len(regions) == 0 {
+		regions = []string{"RegionOne"}
+	}
+
+	s := &Server{
+		PageSize: 25,
+		regions:  regions,
+		stores:   make(map[string]*imagestore.Memory, len(regions)),
+		servers:  make(map[string][]resource, len(regions)),
+		volumes:  make(map[string][]resource, len(regions)),
+	}
+	for _, r := range regions {
+		s.stores[r] = imagestore.NewMemory()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3/auth/tokens", s.handleTokens)
+	mux.HandleFunc("/image/", s.handleImage)
+	mux.HandleFunc("/compute/", s.handleCompute)
+	mux.HandleFunc("/volume/", s.handleVolume)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Store returns images of region.
+func (s *Server) Store(region string) *imagestore.Memory {
+	return s.stores[region]
+}
+
+// AddImages stores imgs in region, images without owner belong to ProjectID.
+func (s *Server) AddImages(region string, imgs ...images.Image) {
+	for i := range imgs {
+		if imgs[i].Owner == "" {
+			imgs[i].Owner = ProjectID
+		}
+	}
+	s.stores[region].Add(imgs...)
+}
+
+// AddServer boots server from image in region.
+func (s *Server) AddServer(region, id, name, imageID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.servers[region] = append(s.servers[region], resource{id, name, imageID})
+}
+
+// AddVolume creates volume from image in region.
+func (s *Server) AddVolume(region, id, name, imageID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.volumes[region] = append(s.volumes[region], resource{id, name, imageID})
+}
+
+// FailImageRequests answers the next n image requests with status and
+// header, e.g. to test retries.
+func (s *Server) FailImageRequests(n, status int, header http.Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, failure{status, header})
+	}
+}
+
+// nextFailure pops the failure the next image request gets.
+func (s *Server) nextFailure() (failure, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.failures) == 0 {
+		return failure{}, false
+	}
+	f := s.failures[0]
+	s.failures = s.failures[1:]
+	return f, true
+}
+
+// Setenv points OS_* variables of the test to the server.
+func (s *Server) Setenv(t testing.TB) {
+	t.Helper()
+	t.Setenv("OS_AUTH_URL", s.URL+"/v3")
+	t.Setenv("OS_USERNAME", username)
+	t.Setenv("OS_PASSWORD", password)
+	t.Setenv("OS_PROJECT_ID", ProjectID)
+	t.Setenv("OS_DOMAIN_NAME", "Default")
+	t.Setenv("OS_REGION_NAME", s.regions[0])
+	t.Setenv("OS_CLOUD", "")
+}
+
+func (s *Server) handleTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Auth struct {
+			Identity struct {
+				Password struct {
+					User struct {
+						Name     string `json:"name"`
+						Password string `json:"password"`
+					} `json:"user"`
+				} `json:"password"`
+			} `json:"identity"`
+		} `json:"auth"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	user := req.Auth.Identity.Password.User
+	if user.Name != username || user.Password != password {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+
+	domain := map[string]string{"id": "default", "name": "Default"}
+
+	w.Header().Set("X-Subject-Token", Token)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"token": map[string]interface{}{
+			"methods":    []string{"password"},
+			"expires_at": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+			"issued_at":  time.Now().UTC().Format(time.RFC3339),
+			"user":       map[string]interface{}{"id": username, "name": username, "domain": domain},
+			"project":    map[string]interface{}{"id": ProjectID, "name": "housekeeper", "domain": domain},
+			"roles":      []map[string]string{{"id": "member", "name": "member"}},
+			"catalog": []map[string]interface{}{
+				s.catalogEntry("glance", "image", "/image/%s/"),
+				s.catalogEntry("nova", "compute", "/compute/%s/v2.1/"),
+				s.catalogEntry("cinderv3", "volumev3", "/volume/%s/v3/"+ProjectID+"/"),
+			},
+		},
+	})
+}
+
+// catalogEntry returns service with endpoints in every region, path has
+// a placeholder for the region.
+func (s *Server) catalogEntry(name, kind, path string) map[string]interface{} {
+	endpoints := make([]map[string]string, 0, len(s.regions))
+	for _, region := range s.regions {
+		endpoints = append(endpoints, map[string]string{
+			"id":        kind + "-" + region,
+			"interface": "public",
+			"region":    region,
+			"region_id": region,
+			"url":       s.URL + fmt.Sprintf(path, region),
+		})
+	}
+
+	return map[string]interface{}{
+		"id":        name,
+		"name":      name,
+		"type":      kind,
+		"endpoints": endpoints,
+	}
+}
+
+// handleCompute serves /compute/<region>/v2.1/servers/detail filtered by image.
+func (s *Server) handleCompute(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Auth-Token") != Token {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/compute/"), "/"), "/")
+	if len(parts) != 4 || parts[1] != "v2.1" || parts[2] != "servers" || parts[3] != "detail" || r.Method != http.MethodGet {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+
+	image := r.URL.Query().Get("image")
+	output := []map[string]interface{}{}
+	s.mu.Lock()
+	for _, server := range s.servers[parts[0]] {
+		if image == "" || server.ImageID == image {
+			output = append(output, map[string]interface{}{
+				"id":        server.ID,
+				"name":      server.Name,
+				"tenant_id": ProjectID,
+				"status":    "ACTIVE",
+				"image":     map[string]string{"id": server.ImageID},
+			})
+		}
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"servers": output})
+}
+
+// handleVolume serves /volume/<region>/v3/<project>/volumes/detail.
+func (s *Server) handleVolume(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Auth-Token") != Token {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/volume/"), "/"), "/")
+	if len(parts) != 5 || parts[1] != "v3" || parts[3] != "volumes" || parts[4] != "detail" || r.Method != http.MethodGet {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+
+	output := []map[string]interface{}{}
+	s.mu.Lock()
+	for _, volume := range s.volumes[parts[0]] {
+		output = append(output, map[string]interface{}{
+			"id":                    volume.ID,
+			"name":                  volume.Name,
+			"status":                "in-use",
+			"volume_image_metadata": map[string]string{"image_id": volume.ImageID},
+		})
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"volumes": output})
+}
+
+// handleImage serves /image/<region>/v2/images[/<id>[/members]].
+func (s *Server) handleImage(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Auth-Token") != Token {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+
+	if f, ok := s.nextFailure(); ok {
+		for k, v := range f.header {
+			w.Header()[k] = v
+		}
+		writeError(w, f.status)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/image/"), "/"), "/")
+	if len(parts) < 3 || parts[1] != "v2" || parts[2] != "images" {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+	store, ok := s.stores[parts[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		listImages(w, r, store, s.PageSize)
+	case len(parts) == 4 && r.Method == http.MethodGet:
+		img, err := store.Get(r.Context(), parts[3])
+		writeResult(w, http.StatusOK, img, err)
+	case len(parts) == 4 && r.Method == http.MethodPatch:
+		updateImage(w, r, store, parts[3])
+	case len(parts) == 4 && r.Method == http.MethodDelete:
+		err := store.Delete(r.Context(), parts[3])
+		writeResult(w, http.StatusNoContent, nil, err)
+	case len(parts) == 5 && parts[4] == "members" && r.Method == http.MethodGet:
+		m, err := store.Members(r.Context(), parts[3])
+		writeResult(w, http.StatusOK, map[string]interface{}{"members": m, "schema": "/v2/schemas/members"}, err)
+	default:
+		writeError(w, http.StatusNotFound)
+	}
+}
+
+// listImages filters images like Glance and pages them by limit and marker.
+func listImages(w http.ResponseWriter, r *http.Request, store *imagestore.Memory, pageSize int) {
+	query := r.URL.Query()
+	createdAt, err := parseDateQuery(query.Get("created_at"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	updatedAt, err := parseDateQuery(query.Get("updated_at"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	opts := images.ListOpts{
+		ID:         query.Get("id"),
+		Name:       query.Get("name"),
+		Owner:      query.Get("owner"),
+		Visibility: images.ImageVisibility(query.Get("visibility")),
+		Status:     images.ImageStatus(query.Get("status")),
+		Hidden:     query.Get("os_hidden") == "true",
+		Tags:       query["tag"],
+		Sort:       query.Get("sort"),
+
+		CreatedAtQuery: createdAt,
+		UpdatedAtQuery: updatedAt,
+	}
+	imgs, err := store.List(r.Context(), opts)
+	if err != nil {
+		writeResult(w, 0, nil, err)
+		return
+	}
+
+	if marker := query.Get("marker"); marker != "" {
+		idx := slices.IndexFunc(imgs, func(i images.Image) bool { return i.ID == marker })
+		if idx == -1 {
+			writeError(w, http.StatusBadRequest)
+			return
+		}
+		imgs = imgs[idx+1:]
+	}
+
+	body := map[string]interface{}{
+		"first":  "/v2/images",
+		"schema": "/v2/schemas/images",
+	}
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 || (pageSize > 0 && limit > pageSize) {
+		limit = pageSize
+	}
+	if limit > 0 && limit < len(imgs) {
+		imgs = imgs[:limit]
+		next := url.Values{}
+		for k, v := range query {
+			next[k] = v
+		}
+		next.Set("marker", imgs[len(imgs)-1].ID)
+		body["next"] = "/v2/images?" + next.Encode()
+	}
+
+	output := make([]map[string]interface{}, 0, len(imgs))
+	for _, i := range imgs {
+		output = append(output, imageBody(i))
+	}
+	body["images"] = output
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+// parseDateQuery parses Glance time filter "[operator:]RFC3339", empty filter is nil.
+func parseDateQuery(s string) (*images.ImageDateQuery, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	filter, date, ok := strings.Cut(s, ":")
+	if !ok || !slices.Contains([]images.ImageDateFilter{
+		images.FilterGT, images.FilterGTE, images.FilterLT,
+		images.FilterLTE, images.FilterNEQ, images.FilterEQ,
+	}, images.ImageDateFilter(filter)) {
+		filter, date = string(images.FilterEQ), s
+	}
+	t, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return nil, err
+	}
+
+	return &images.ImageDateQuery{Date: t, Filter: images.ImageDateFilter(filter)}, nil
+}
+
+// patchDocument is a JSON patch request body applied to an image.
+type patchDocument []interface{}
+
+func (p patchDocument) ToImageUpdateMap() ([]interface{}, error) {
+	return p, nil
+}
+
+func updateImage(w http.ResponseWriter, r *http.Request, store *imagestore.Memory, id string) {
+	var patch patchDocument
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+
+	img, err := store.Update(r.Context(), id, patch)
+	writeResult(w, http.StatusOK, img, err)
+}
+
+// imageBody renders image the way Glance does, properties are top level keys.
+func imageBody(i images.Image) map[string]interface{} {
+	body := make(map[string]interface{}, len(i.Properties)+16)
+	for k, v := range i.Properties {
+		body[k] = v
+	}
+	status := i.Status
+	if status == "" {
+		status = images.ImageStatusActive
+	}
+	tags := i.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	body["id"] = i.ID
+	body["name"] = i.Name
+	body["status"] = status
+	body["tags"] = tags
+	body["container_format"] = i.ContainerFormat
+	body["disk_format"] = i.DiskFormat
+	body["min_disk"] = i.MinDiskGigabytes
+	body["min_ram"] = i.MinRAMMegabytes
+	body["owner"] = i.Owner
+	body["protected"] = i.Protected
+	body["visibility"] = i.Visibility
+	body["os_hidden"] = i.Hidden
+	body["checksum"] = i.Checksum
+	body["size"] = i.SizeBytes
+	body["virtual_size"] = i.VirtualSize
+	body["created_at"] = i.CreatedAt.UTC().Format(time.RFC3339)
+	body["updated_at"] = i.UpdatedAt.UTC().Format(time.RFC3339)
+	body["file"] = fmt.Sprintf("/v2/images/%s/file", i.ID)
+	body["self"] = fmt.Sprintf("/v2/images/%s", i.ID)
+	body["schema"] = "/v2/schemas/image"
+
+	return body
+}
+
+// writeResult writes body with code or the status of err returned by the store.
+func writeResult(w http.ResponseWriter, code int, body interface{}, err error) {
+	if err != nil {
+		var statusErr gophercloud.StatusCodeError
+		if errors.As(err, &statusErr) {
+			writeError(w, statusErr.GetStatusCode())
+			return
+		}
+		writeError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if img, ok := body.(*images.Image); ok {
+		body = imageBody(*img)
+	}
+	writeJSON(w, code, body)
+}
+
+func writeError(w http.ResponseWriter, code int) {
+	writeJSON(w, code, map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": http.StatusText(code),
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if body != nil && code != http.StatusNoContent {
+		json.NewEncoder(w).Encode(body) //nolint:errcheck,errchkjson // client is gone if it fails
+	}
+}
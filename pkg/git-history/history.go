@@ -1,60 +1,266 @@
 package githistory
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
 	log "github.com/hornwind/openstack-image-keeper/pkg/logging"
 )
 
-func GetNCommitsFromHead(scanDepth int) ([]string, error) {
+// Options selects the repository history is read from.
+type Options struct {
+	// Path of a local repository, the current directory when empty.
+	Path string
+	// URL of a remote repository cloned in memory, replaces Path.
+	URL string
+	// Ref is a branch, tag or commit history is scanned from, HEAD when empty.
+	Ref string
+	// Token authenticates HTTP(S) clone of URL.
+	Token string
+	// SSHKey is a private key file authenticating SSH clone of URL,
+	// SSH agent is used when empty.
+	SSHKey string
+}
+
+// Repo reads history of a git repository.
+type Repo struct {
+	repo   *git.Repository
+	ref    string
+	remote bool
+}
+
+// Open opens the local repository or clones the remote one.
+func Open(opts Options) (*Repo, error) {
 	log := log.GetLogger()
-	var output []string
 
-	pwd, err := os.Getwd()
+	if opts.URL != "" {
+		auth, err := opts.auth()
+		if err != nil {
+			return nil, err
+		}
+		log.Debugf("clone %s", opts.URL)
+		repo, err := git.Clone(memory.NewStorage(), nil, &git.CloneOptions{
+			URL:        opts.URL,
+			Auth:       auth,
+			NoCheckout: true,
+			Tags:       git.AllTags,
+		})
+		if err != nil {
+			log.Debug(err)
+			return nil, fmt.Errorf("clone %s: %w", opts.URL, err)
+		}
+		return &Repo{repo: repo, ref: opts.Ref, remote: true}, nil
+	}
+
+	path := opts.Path
+	if path == "" {
+		pwd, err := os.Getwd()
+		if err != nil {
+			log.Debug(err)
+			return nil, err
+		}
+		path = pwd
+	}
+
+	repo, err := git.PlainOpenWithOptions(path, &git.PlainOpenOptions{DetectDotGit: opts.Path != ""})
 	if err != nil {
 		log.Debug(err)
-		return make([]string, 0), err
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+
+	return &Repo{repo: repo, ref: opts.Ref}, nil
+}
+
+// auth returns credentials for URL, nil leaves defaults of go-git.
+func (o Options) auth() (transport.AuthMethod, error) {
+	switch {
+	case o.Token != "":
+		// GitHub and GitLab accept any non-empty user name with a token
+		return &http.BasicAuth{Username: "oauth2", Password: o.Token}, nil
+	case o.SSHKey != "":
+		return ssh.NewPublicKeysFromFile("git", o.SSHKey, "")
+	default:
+		return nil, nil
+	}
+}
+
+// start returns the commit history is scanned from.
+func (r *Repo) start() (plumbing.Hash, error) {
+	if r.ref == "" {
+		head, err := r.repo.Head()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return head.Hash(), nil
 	}
 
-	repo, err := git.PlainOpen(pwd)
+	hash, err := r.repo.ResolveRevision(plumbing.Revision(r.ref))
+	if err != nil && r.remote {
+		// only the default branch of a clone is local
+		hash, err = r.repo.ResolveRevision(plumbing.Revision(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, r.ref)))
+	}
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("resolve %s: %w", r.ref, err)
+	}
+
+	return *hash, nil
+}
+
+// commitPrefix matches commit hashes abbreviated to 7 digits at least, like git does.
+var commitPrefix = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// ParseCommit returns the lower-cased commit hash or hash prefix s, prefixes
+// shorter than 7 digits match too many commits and are rejected.
+func ParseCommit(s string) (string, error) {
+	hash := strings.ToLower(s)
+	if !commitPrefix.MatchString(hash) {
+		return "", fmt.Errorf("commit %q must be 7 to 40 hex digits", s)
+	}
+
+	return hash, nil
+}
+
+// Unlimited is the Window depth which doesn't limit number of commits.
+const Unlimited = -1
+
+// Window selects commits returned by Scan.
+type Window struct {
+	// Depth limits number of commits, Unlimited scans the whole history.
+	Depth int
+	// FirstParent follows only the first parent of merges, so commits of
+	// merged branches don't take place of mainline commits.
+	FirstParent bool
+	// Since stops at commits committed before the time.
+	Since time.Time
+	// Until stops at the commit with the hash or hash prefix, inclusive,
+	// see ParseCommit.
+	Until string
+}
+
+// Commits returns hashes of scanDepth commits from the ref.
+func (r *Repo) Commits(scanDepth int) ([]string, error) {
+	return r.Scan(Window{Depth: scanDepth})
+}
+
+// Scan returns hashes of commits of the window from the ref, newest first.
+func (r *Repo) Scan(w Window) ([]string, error) {
+	log := log.GetLogger()
+	output := []string{}
+
+	if w.Until != "" {
+		until, err := ParseCommit(w.Until)
+		if err != nil {
+			return make([]string, 0), err
+		}
+		w.Until = until
+	}
+
+	from, err := r.start()
 	if err != nil {
 		log.Debug(err)
 		return make([]string, 0), err
 	}
 
-	logOpts := &git.LogOptions{}
+	next, err := r.walk(from, w.FirstParent)
+	if err != nil {
+		log.Debug(err)
+		return make([]string, 0), err
+	}
 
-	iter, _ := repo.Log(logOpts)
+	for w.Depth == Unlimited || len(output) < w.Depth {
+		commit, err := next()
+		if err == io.EOF {
+			if w.Until != "" {
+				return make([]string, 0), fmt.Errorf("commit %s not found in history of %s", w.Until, from)
+			}
+			break
+		}
+		if err != nil {
+			log.Debug(err)
+			return make([]string, 0), err
+		}
 
-	for i := 0; i < scanDepth; i++ {
-		if commit, err := iter.Next(); commit != nil {
-			if err != nil {
-				log.Debug(err)
-				return make([]string, 0), err
+		if !w.Since.IsZero() && commit.Committer.When.Before(w.Since) {
+			if w.FirstParent {
+				// the first parent chain only gets older
+				break
 			}
-			output = append(output, commit.Hash.String())
+			continue
+		}
+
+		hash := commit.Hash.String()
+		output = append(output, hash)
+		if w.Until != "" && strings.HasPrefix(hash, w.Until) {
+			break
 		}
 	}
 
 	return output, nil
 }
 
-func GetCurrentBranch() (string, error) {
-	log := log.GetLogger()
-	pwd, err := os.Getwd()
-	if err != nil {
-		log.Debug(err)
-		return "", err
+// walk returns iterator over commits from the hash, io.EOF ends history.
+func (r *Repo) walk(from plumbing.Hash, firstParent bool) (func() (*object.Commit, error), error) {
+	if !firstParent {
+		iter, err := r.repo.Log(&git.LogOptions{From: from})
+		if err != nil {
+			return nil, err
+		}
+		return iter.Next, nil
 	}
 
-	repo, err := git.PlainOpen(pwd)
+	commit, err := r.repo.CommitObject(from)
 	if err != nil {
-		log.Debug(err)
-		return "", err
+		return nil, err
+	}
+
+	return func() (*object.Commit, error) {
+		if commit == nil {
+			return nil, io.EOF
+		}
+		current := commit
+		if current.NumParents() == 0 {
+			commit = nil
+			return current, nil
+		}
+		parent, err := current.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		commit = parent
+		return current, nil
+	}, nil
+}
+
+// CurrentBranch returns the branch of the ref, HEAD when the ref isn't a branch
+// or HEAD is detached.
+func (r *Repo) CurrentBranch() (string, error) {
+	log := log.GetLogger()
+
+	if r.ref != "" {
+		names := []plumbing.ReferenceName{plumbing.NewBranchReferenceName(r.ref)}
+		if r.remote {
+			names = append(names, plumbing.NewRemoteReferenceName(git.DefaultRemoteName, r.ref))
+		}
+		for _, name := range names {
+			if _, err := r.repo.Reference(name, false); err == nil {
+				return r.ref, nil
+			}
+		}
+		return plumbing.HEAD.String(), nil
 	}
 
-	ref, err := repo.Head()
+	ref, err := r.repo.Head()
 	if err != nil {
 		log.Debug(err)
 		return "", err
@@ -63,24 +269,17 @@ func GetCurrentBranch() (string, error) {
 	return ref.Name().Short(), nil
 }
 
-func GetTags(scanDepth int) ([]string, error) {
+// Tags returns names of scanDepth tags.
+func (r *Repo) Tags(scanDepth int) ([]string, error) {
 	log := log.GetLogger()
 	var output []string
 
-	pwd, err := os.Getwd()
+	iter, err := r.repo.Tags()
 	if err != nil {
 		log.Debug(err)
 		return make([]string, 0), err
 	}
 
-	repo, err := git.PlainOpen(pwd)
-	if err != nil {
-		log.Debug(err)
-		return make([]string, 0), err
-	}
-
-	iter, _ := repo.Tags()
-
 	for i := 0; i < scanDepth; i++ {
 		if ref, err := iter.Next(); ref != nil {
 			if err != nil {
@@ -93,3 +292,79 @@ func GetTags(scanDepth int) ([]string, error) {
 
 	return output, nil
 }
+
+// TagCommits returns hashes of commits pointed to by tags of the repository,
+// annotated tags are resolved to their commits.
+func (r *Repo) TagCommits() (map[string]string, error) {
+	log := log.GetLogger()
+	output := make(map[string]string)
+
+	iter, err := r.repo.Tags()
+	if err != nil {
+		log.Debug(err)
+		return output, err
+	}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		hash := ref.Hash()
+		if tag, err := r.repo.TagObject(hash); err == nil {
+			commit, err := tag.Commit()
+			if err != nil {
+				// tags of trees and blobs can't be matched with images
+				log.Debugf("skip tag %s: %s", ref.Name().Short(), err)
+				return nil
+			}
+			hash = commit.Hash
+		}
+		output[ref.Name().Short()] = hash.String()
+		return nil
+	})
+	if err != nil {
+		log.Debug(err)
+		return make(map[string]string), err
+	}
+
+	return output, nil
+}
+
+// GetNCommitsFromHead returns hashes of scanDepth commits from HEAD of
+// the repository in the current directory.
+func GetNCommitsFromHead(scanDepth int) ([]string, error) {
+	repo, err := Open(Options{})
+	if err != nil {
+		return make([]string, 0), err
+	}
+
+	return repo.Commits(scanDepth)
+}
+
+// GetCurrentBranch returns the branch of the repository in the current directory.
+func GetCurrentBranch() (string, error) {
+	repo, err := Open(Options{})
+	if err != nil {
+		return "", err
+	}
+
+	return repo.CurrentBranch()
+}
+
+// GetTags returns names of scanDepth tags of the repository in the current directory.
+func GetTags(scanDepth int) ([]string, error) {
+	repo, err := Open(Options{})
+	if err != nil {
+		return make([]string, 0), err
+	}
+
+	return repo.Tags(scanDepth)
+}
+
+// GetTagCommits returns hashes of commits pointed to by tags of the repository
+// in the current directory.
+func GetTagCommits() (map[string]string, error) {
+	repo, err := Open(Options{})
+	if err != nil {
+		return make(map[string]string), err
+	}
+
+	return repo.TagCommits()
+}
@@ -0,0 +1,84 @@
+package imagestore
+
+import (
+	"context"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/members"
+)
+
+var _ ImageStore = (*Glance)(nil)
+
+// Glance is an ImageStore backed by the Glance v2 API. Gophercloud requests
+// can't be cancelled, so ctx is checked before each call.
+type Glance struct {
+	client *gophercloud.ServiceClient
+	owner  string
+}
+
+// NewGlance returns an ImageStore for images owned by the project.
+func NewGlance(client *gophercloud.ServiceClient, owner string) *Glance {
+	return &Glance{
+		client: client,
+		owner:  owner,
+	}
+}
+
+// List returns all pages of images matching opts.
+func (g *Glance) List(ctx context.Context, opts images.ListOpts) ([]images.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts.Owner == "" {
+		opts.Owner = g.owner
+	}
+
+	allPages, err := images.List(g.client, opts).AllPages()
+	if err != nil {
+		return nil, err
+	}
+
+	return images.ExtractImages(allPages)
+}
+
+// Get returns image by id.
+func (g *Glance) Get(ctx context.Context, id string) (*images.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return images.Get(g.client, id).Extract()
+}
+
+// Update applies opts to image and returns the updated image.
+func (g *Glance) Update(ctx context.Context, id string, opts images.UpdateOptsBuilder) (*images.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return images.Update(g.client, id, opts).Extract()
+}
+
+// Delete deletes image by id.
+func (g *Glance) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return images.Delete(g.client, id).ExtractErr()
+}
+
+// Members returns the projects the image is shared with.
+func (g *Glance) Members(ctx context.Context, id string) ([]members.Member, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	allPages, err := members.List(g.client, id).AllPages()
+	if err != nil {
+		return nil, err
+	}
+
+	return members.ExtractMembers(allPages)
+}
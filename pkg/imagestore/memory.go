@@ -0,0 +1,384 @@
+package imagestore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/members"
+	"golang.org/x/exp/slices"
+)
+
+var _ ImageStore = (*Memory)(nil)
+
+// Memory is an in-memory ImageStore which mimics Glance behaviour,
+// it is intended for tests.
+type Memory struct {
+	mu      sync.Mutex
+	images  []images.Image
+	members map[string][]members.Member
+}
+
+// NewMemory returns a Memory store filled with imgs.
+func NewMemory(imgs ...images.Image) *Memory {
+	m := &Memory{
+		images:  make([]images.Image, 0, len(imgs)),
+		members: make(map[string][]members.Member),
+	}
+	m.Add(imgs...)
+
+	return m
+}
+
+// Add stores imgs, images with existing ids are replaced.
+func (m *Memory) Add(imgs ...images.Image) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, i := range imgs {
+		if idx := m.index(i.ID); idx != -1 {
+			m.images[idx] = cloneImage(i)
+			continue
+		}
+		m.images = append(m.images, cloneImage(i))
+	}
+}
+
+// Images returns a copy of all stored images in insertion order.
+func (m *Memory) Images() []images.Image {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	output := make([]images.Image, 0, len(m.images))
+	for _, i := range m.images {
+		output = append(output, cloneImage(i))
+	}
+
+	return output
+}
+
+// AddMember shares image with project.
+func (m *Memory) AddMember(imageID, projectID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	m.members[imageID] = append(m.members[imageID], members.Member{
+		CreatedAt: now,
+		UpdatedAt: now,
+		ImageID:   imageID,
+		MemberID:  projectID,
+		Status:    "accepted",
+	})
+}
+
+// List returns images matching opts sorted like Glance does, newest first by default.
+// Limit and Marker are ignored as the whole result is always returned.
+func (m *Memory) List(ctx context.Context, opts images.ListOpts) ([]images.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	output := []images.Image{}
+	for _, i := range m.images {
+		if matchListOpts(i, opts) {
+			output = append(output, cloneImage(i))
+		}
+	}
+	sortImages(output, opts.Sort)
+
+	return output, nil
+}
+
+// Get returns image by id.
+func (m *Memory) Get(ctx context.Context, id string) (*images.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	idx := m.index(id)
+	if idx == -1 {
+		return nil, errNotFound(http.MethodGet, id)
+	}
+	img := cloneImage(m.images[idx])
+
+	return &img, nil
+}
+
+// Update applies JSON patch operations from opts to image.
+func (m *Memory) Update(ctx context.Context, id string, opts images.UpdateOptsBuilder) (*images.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	patches, err := opts.ToImageUpdateMap()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	idx := m.index(id)
+	if idx == -1 {
+		return nil, errNotFound(http.MethodPatch, id)
+	}
+
+	img := cloneImage(m.images[idx])
+	for _, p := range patches {
+		patch, ok := p.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unsupported patch %v", p)
+		}
+		if err := applyPatch(&img, patch); err != nil {
+			return nil, err
+		}
+	}
+	img.UpdatedAt = time.Now().UTC()
+	m.images[idx] = img
+
+	output := cloneImage(img)
+	return &output, nil
+}
+
+// Delete deletes image by id, protected images can't be deleted.
+func (m *Memory) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	idx := m.index(id)
+	if idx == -1 {
+		return errNotFound(http.MethodDelete, id)
+	}
+	if m.images[idx].Protected {
+		return gophercloud.ErrDefault403{
+			ErrUnexpectedResponseCode: unexpectedResponse(http.MethodDelete, id, http.StatusForbidden, "image is protected"),
+		}
+	}
+
+	m.images = slices.Delete(m.images, idx, idx+1)
+	delete(m.members, id)
+
+	return nil
+}
+
+// Members returns the projects the image is shared with.
+func (m *Memory) Members(ctx context.Context, id string) ([]members.Member, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.index(id) == -1 {
+		return nil, errNotFound(http.MethodGet, id)
+	}
+
+	return slices.Clone(m.members[id]), nil
+}
+
+func (m *Memory) index(id string) int {
+	return slices.IndexFunc(m.images, func(i images.Image) bool {
+		return i.ID == id
+	})
+}
+
+func matchListOpts(i images.Image, opts images.ListOpts) bool {
+	switch {
+	case opts.ID != "" && i.ID != opts.ID:
+		return false
+	case opts.Name != "" && i.Name != opts.Name:
+		return false
+	case opts.Owner != "" && i.Owner != opts.Owner:
+		return false
+	case opts.Visibility != "" && i.Visibility != opts.Visibility:
+		return false
+	case opts.Status != "" && i.Status != opts.Status:
+		return false
+	case i.Hidden != opts.Hidden:
+		// Glance lists only hidden images with os_hidden=true and skips them otherwise.
+		return false
+	}
+	for _, tag := range opts.Tags {
+		if !slices.Contains(i.Tags, tag) {
+			return false
+		}
+	}
+
+	return matchDate(i.CreatedAt, opts.CreatedAtQuery) && matchDate(i.UpdatedAt, opts.UpdatedAtQuery)
+}
+
+// matchDate compares t with query the way Glance does, nil query matches everything.
+func matchDate(t time.Time, query *images.ImageDateQuery) bool {
+	if query == nil {
+		return true
+	}
+
+	c := compareTime(t, query.Date)
+	switch query.Filter {
+	case images.FilterGT:
+		return c > 0
+	case images.FilterGTE:
+		return c >= 0
+	case images.FilterLT:
+		return c < 0
+	case images.FilterLTE:
+		return c <= 0
+	case images.FilterNEQ:
+		return c != 0
+	default:
+		return c == 0
+	}
+}
+
+// sortImages sorts images by Glance sort syntax "key[:dir],...",
+// the default is created_at:desc with id as a tiebreaker.
+func sortImages(imgs []images.Image, order string) {
+	if order == "" {
+		order = "created_at:desc"
+	}
+	keys := strings.Split(order+",id:desc", ",")
+
+	sort.SliceStable(imgs, func(a, b int) bool {
+		for _, k := range keys {
+			key, dir, _ := strings.Cut(strings.TrimSpace(k), ":")
+			c := compareImages(imgs[a], imgs[b], key)
+			if c == 0 {
+				continue
+			}
+			if dir == "asc" {
+				return c < 0
+			}
+			return c > 0
+		}
+		return false
+	})
+}
+
+func compareImages(a, b images.Image, key string) int {
+	switch key {
+	case "created_at":
+		return compareTime(a.CreatedAt, b.CreatedAt)
+	case "updated_at":
+		return compareTime(a.UpdatedAt, b.UpdatedAt)
+	case "name":
+		return strings.Compare(a.Name, b.Name)
+	case "size":
+		return compareInt(a.SizeBytes, b.SizeBytes)
+	case "status":
+		return strings.Compare(string(a.Status), string(b.Status))
+	default:
+		return strings.Compare(a.ID, b.ID)
+	}
+}
+
+func compareTime(a, b time.Time) int {
+	return compareInt(a.UnixNano(), b.UnixNano())
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func applyPatch(img *images.Image, patch map[string]interface{}) error {
+	op := fmt.Sprint(patch["op"])
+	path := strings.TrimPrefix(fmt.Sprint(patch["path"]), "/")
+	value := patch["value"]
+
+	switch path {
+	case "name":
+		img.Name = fmt.Sprint(value)
+	case "visibility":
+		img.Visibility = images.ImageVisibility(fmt.Sprint(value))
+	case "protected", "os_hidden":
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("%s must be boolean, got %v", path, value)
+		}
+		if path == "protected" {
+			img.Protected = b
+		} else {
+			img.Hidden = b
+		}
+	case "tags":
+		tags, err := toStrings(value)
+		if err != nil {
+			return err
+		}
+		img.Tags = tags
+	default:
+		if img.Properties == nil {
+			img.Properties = make(map[string]interface{})
+		}
+		if op == string(images.RemoveOp) {
+			delete(img.Properties, path)
+			return nil
+		}
+		img.Properties[path] = value
+	}
+
+	return nil
+}
+
+func toStrings(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case []string:
+		return slices.Clone(v), nil
+	case []interface{}:
+		output := make([]string, 0, len(v))
+		for _, s := range v {
+			output = append(output, fmt.Sprint(s))
+		}
+		return output, nil
+	default:
+		return nil, fmt.Errorf("tags must be list, got %v", value)
+	}
+}
+
+func cloneImage(i images.Image) images.Image {
+	i.Tags = slices.Clone(i.Tags)
+	if i.Properties != nil {
+		properties := make(map[string]interface{}, len(i.Properties))
+		for k, v := range i.Properties {
+			properties[k] = v
+		}
+		i.Properties = properties
+	}
+
+	return i
+}
+
+func errNotFound(method, id string) error {
+	return gophercloud.ErrDefault404{
+		ErrUnexpectedResponseCode: unexpectedResponse(method, id, http.StatusNotFound, "image not found"),
+	}
+}
+
+func unexpectedResponse(method, id string, code int, body string) gophercloud.ErrUnexpectedResponseCode {
+	return gophercloud.ErrUnexpectedResponseCode{
+		Method:   method,
+		URL:      "/v2/images/" + id,
+		Expected: []int{http.StatusOK, http.StatusNoContent},
+		Actual:   code,
+		Body:     []byte(body),
+	}
+}
@@ -0,0 +1,188 @@
+package imagestore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/members"
+	log "github.com/hornwind/openstack-image-keeper/pkg/logging"
+)
+
+var _ ImageStore = (*Retry)(nil)
+
+// Retry is an ImageStore which repeats calls of the wrapped store failed with
+// transient errors: 409 Conflict, 5xx, 413 and 429 and connection resets.
+// The delay starts at backoff and doubles with every retry, Retry-After of
+// 413 and 429 responses replaces it.
+type Retry struct {
+	store   ImageStore
+	retries int
+	backoff time.Duration
+	// sleep waits for d or until ctx is done, replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewRetry returns store which retries failed calls of store up to retries times.
+func NewRetry(store ImageStore, retries int, backoff time.Duration) *Retry {
+	return &Retry{
+		store:   store,
+		retries: retries,
+		backoff: backoff,
+		sleep:   sleep,
+	}
+}
+
+// List returns images matching opts.
+func (r *Retry) List(ctx context.Context, opts images.ListOpts) ([]images.Image, error) {
+	var output []images.Image
+	err := r.do(ctx, "list images", func() (err error) {
+		output, err = r.store.List(ctx, opts)
+		return err
+	})
+
+	return output, err
+}
+
+// Get returns image by id.
+func (r *Retry) Get(ctx context.Context, id string) (*images.Image, error) {
+	var output *images.Image
+	err := r.do(ctx, "get image "+id, func() (err error) {
+		output, err = r.store.Get(ctx, id)
+		return err
+	})
+
+	return output, err
+}
+
+// Update applies opts to image and returns the updated image.
+func (r *Retry) Update(ctx context.Context, id string, opts images.UpdateOptsBuilder) (*images.Image, error) {
+	var output *images.Image
+	err := r.do(ctx, "update image "+id, func() (err error) {
+		output, err = r.store.Update(ctx, id, opts)
+		return err
+	})
+
+	return output, err
+}
+
+// Delete deletes image by id. A retried deletion which doesn't find the image
+// succeeded, the failed attempt deleted it before the error.
+func (r *Retry) Delete(ctx context.Context, id string) error {
+	attempt := 0
+	return r.do(ctx, "delete image "+id, func() error {
+		attempt++
+		err := r.store.Delete(ctx, id)
+		if attempt > 1 && statusCode(err) == http.StatusNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// Members returns the projects the image is shared with.
+func (r *Retry) Members(ctx context.Context, id string) ([]members.Member, error) {
+	var output []members.Member
+	err := r.do(ctx, "list members of image "+id, func() (err error) {
+		output, err = r.store.Members(ctx, id)
+		return err
+	})
+
+	return output, err
+}
+
+// do calls fn until it succeeds, fails with a permanent error or retries run out.
+func (r *Retry) do(ctx context.Context, op string, fn func() error) error {
+	log := log.GetLogger()
+
+	delay := r.backoff
+	for retry := 1; ; retry++ {
+		err := fn()
+		if err == nil || retry > r.retries || !transient(err) {
+			return err
+		}
+
+		wait := delay
+		if d, ok := retryAfter(err); ok {
+			wait = d
+		}
+		log.Debugf("%s: retry %d of %d in %s: %s", op, retry, r.retries, wait, err)
+		if err := r.sleep(ctx, wait); err != nil {
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// transient reports if a call failed with err may succeed when repeated.
+func transient(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	switch code := statusCode(err); {
+	case code == http.StatusConflict,
+		code == http.StatusRequestEntityTooLarge,
+		code == http.StatusTooManyRequests:
+		return true
+	default:
+		return code >= http.StatusInternalServerError
+	}
+}
+
+// statusCode returns HTTP status of the response err was built from, 0 when
+// there was no response.
+func statusCode(err error) int {
+	var statusErr gophercloud.StatusCodeError
+	if errors.As(err, &statusErr) {
+		return statusErr.GetStatusCode()
+	}
+
+	return 0
+}
+
+// retryAfter returns the delay asked by Retry-After of a 413 or 429 response,
+// the header holds either seconds or an HTTP date.
+func retryAfter(err error) (time.Duration, bool) {
+	var respErr gophercloud.ErrUnexpectedResponseCode
+	if !errors.As(err, &respErr) {
+		return 0, false
+	}
+	if respErr.Actual != http.StatusRequestEntityTooLarge && respErr.Actual != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	value := respErr.ResponseHeader.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,183 @@
+package imagestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/stretchr/testify/suite"
+)
+
+// flakyStore fails calls with queued errors before passing them to Memory.
+type flakyStore struct {
+	*Memory
+	errs  []error
+	calls int
+}
+
+func (f *flakyStore) fail() error {
+	f.calls++
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func (f *flakyStore) List(ctx context.Context, opts images.ListOpts) ([]images.Image, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.Memory.List(ctx, opts)
+}
+
+func (f *flakyStore) Delete(ctx context.Context, id string) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.Memory.Delete(ctx, id)
+}
+
+func statusError(code int, header http.Header) error {
+	return gophercloud.ErrUnexpectedResponseCode{Actual: code, ResponseHeader: header}
+}
+
+type RetrySuite struct {
+	suite.Suite
+	flaky *flakyStore
+	retry *Retry
+	waits []time.Duration
+}
+
+func TestRetry(t *testing.T) {
+	suite.Run(t, &RetrySuite{})
+}
+
+func (rs *RetrySuite) SetupTest() {
+	rs.flaky = &flakyStore{Memory: NewMemory(images.Image{
+		ID:        "f25148bb-fc89-4787-abfa-4889e455c3f8",
+		CreatedAt: time.Now(),
+	})}
+	rs.waits = nil
+	rs.retry = NewRetry(rs.flaky, 3, time.Second)
+	rs.retry.sleep = func(_ context.Context, d time.Duration) error {
+		rs.waits = append(rs.waits, d)
+		return nil
+	}
+}
+
+func (rs *RetrySuite) TestTransient() {
+	rs.flaky.errs = []error{
+		gophercloud.ErrDefault503{ErrUnexpectedResponseCode: gophercloud.ErrUnexpectedResponseCode{Actual: http.StatusServiceUnavailable}},
+		gophercloud.ErrDefault409{ErrUnexpectedResponseCode: gophercloud.ErrUnexpectedResponseCode{Actual: http.StatusConflict}},
+		&url.Error{Op: "Get", URL: "http://glance", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+	}
+
+	imgs, err := rs.retry.List(context.Background(), images.ListOpts{})
+
+	rs.Require().NoError(err)
+	rs.Assert().Len(imgs, 1)
+	rs.Assert().Equal(4, rs.flaky.calls)
+	rs.Assert().Equal([]time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, rs.waits)
+}
+
+func (rs *RetrySuite) TestRetriesExhausted() {
+	for i := 0; i < 4; i++ {
+		rs.flaky.errs = append(rs.flaky.errs, statusError(http.StatusBadGateway, nil))
+	}
+
+	_, err := rs.retry.List(context.Background(), images.ListOpts{})
+
+	rs.Require().Error(err)
+	rs.Assert().Equal(http.StatusBadGateway, statusCode(err))
+	rs.Assert().Equal(4, rs.flaky.calls)
+}
+
+func (rs *RetrySuite) TestPermanent() {
+	for _, code := range []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound} {
+		rs.flaky.calls = 0
+		rs.flaky.errs = []error{statusError(code, nil)}
+
+		_, err := rs.retry.List(context.Background(), images.ListOpts{})
+
+		rs.Require().Error(err, code)
+		rs.Assert().Equal(1, rs.flaky.calls, code)
+	}
+	rs.Assert().Empty(rs.waits)
+}
+
+func (rs *RetrySuite) TestRetryAfter() {
+	rs.flaky.errs = []error{
+		gophercloud.ErrDefault429{ErrUnexpectedResponseCode: gophercloud.ErrUnexpectedResponseCode{
+			Actual:         http.StatusTooManyRequests,
+			ResponseHeader: http.Header{"Retry-After": {"7"}},
+		}},
+		statusError(http.StatusRequestEntityTooLarge, http.Header{"Retry-After": {"2"}}),
+		statusError(http.StatusServiceUnavailable, http.Header{"Retry-After": {"30"}}),
+	}
+
+	_, err := rs.retry.List(context.Background(), images.ListOpts{})
+
+	rs.Require().NoError(err)
+	// Retry-After of 503 is ignored, the backoff keeps doubling
+	rs.Assert().Equal([]time.Duration{7 * time.Second, 2 * time.Second, 4 * time.Second}, rs.waits)
+}
+
+func (rs *RetrySuite) TestRetryAfterDate() {
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	d, ok := retryAfter(statusError(http.StatusTooManyRequests, http.Header{"Retry-After": {date}}))
+
+	rs.Require().True(ok)
+	rs.Assert().InDelta(time.Hour, d, float64(2*time.Second))
+}
+
+func (rs *RetrySuite) TestDeleteRetriedNotFound() {
+	// the first attempt deleted the image but its response was lost
+	rs.flaky.Memory = NewMemory()
+	rs.flaky.errs = []error{statusError(http.StatusGatewayTimeout, nil)}
+
+	err := rs.retry.Delete(context.Background(), "f25148bb-fc89-4787-abfa-4889e455c3f8")
+
+	rs.Require().NoError(err)
+	rs.Assert().Equal(2, rs.flaky.calls)
+}
+
+func (rs *RetrySuite) TestDeleteNotFound() {
+	err := rs.retry.Delete(context.Background(), "00000000-0000-0000-0000-000000000000")
+
+	rs.Require().Error(err)
+	rs.Assert().Equal(http.StatusNotFound, statusCode(err))
+}
+
+func (rs *RetrySuite) TestCanceled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rs.retry.sleep = sleep
+	rs.flaky.errs = []error{statusError(http.StatusInternalServerError, nil)}
+
+	_, err := rs.retry.List(ctx, images.ListOpts{})
+
+	rs.Require().ErrorIs(err, context.Canceled)
+	rs.Assert().Equal(1, rs.flaky.calls)
+}
+
+func (rs *RetrySuite) TestDisabled() {
+	rs.retry.retries = 0
+	rs.flaky.errs = []error{fmt.Errorf("list: %w", statusError(http.StatusInternalServerError, nil))}
+
+	_, err := rs.retry.List(context.Background(), images.ListOpts{})
+
+	rs.Require().Error(err)
+	rs.Assert().Equal(1, rs.flaky.calls)
+	rs.Assert().True(errors.As(err, new(gophercloud.ErrUnexpectedResponseCode)))
+}
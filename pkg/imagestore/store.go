@@ -0,0 +1,18 @@
+package imagestore
+
+import (
+	"context"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/members"
+)
+
+// ImageStore is a project scoped storage of images, implemented by Glance.
+type ImageStore interface {
+	// List returns images matching opts, the owner is set by the store.
+	List(ctx context.Context, opts images.ListOpts) ([]images.Image, error)
+	Get(ctx context.Context, id string) (*images.Image, error)
+	Update(ctx context.Context, id string, opts images.UpdateOptsBuilder) (*images.Image, error)
+	Delete(ctx context.Context, id string) error
+	Members(ctx context.Context, id string) ([]members.Member, error)
+}
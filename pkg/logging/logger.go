@@ -32,7 +32,10 @@ func (hook *writerHook) Levels() []logrus.Level {
 	return hook.LogLevels
 }
 
-var e *logrus.Entry
+var (
+	e    *logrus.Entry
+	hook *writerHook
+)
 
 type Logger struct {
 	*logrus.Entry
@@ -50,6 +53,11 @@ func (l *Logger) GetLoggerWithFields(fields logrus.Fields) *Logger {
 	return &Logger{l.WithFields(fields)}
 }
 
+// SetOutput replaces writers of log lines, stdout is used by default.
+func (l *Logger) SetOutput(w ...io.Writer) {
+	hook.Writer = w
+}
+
 func (l *Logger) SetLogLevel(level string) error {
 	ll, err := logrus.ParseLevel(level)
 	if err != nil {
@@ -73,10 +81,11 @@ func init() {
 
 	l.SetOutput(io.Discard)
 
-	l.AddHook(&writerHook{
+	hook = &writerHook{
 		Writer:    []io.Writer{os.Stdout},
 		LogLevels: logrus.AllLevels,
-	})
+	}
+	l.AddHook(hook)
 
 	l.SetLevel(logrus.InfoLevel)
 
@@ -0,0 +1,62 @@
+package retention
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var ageUnitRe = regexp.MustCompile(`(\d+(?:\.\d+)?)([a-zµ]+)`)
+
+// ParseAge parses Go duration extended by 'd' (24h) and 'w' (7d) units.
+func ParseAge(s string) (time.Duration, error) {
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+
+	matches := ageUnitRe.FindAllStringSubmatchIndex(s, -1)
+	var total time.Duration
+	end := 0
+	for _, m := range matches {
+		if m[0] != end {
+			break
+		}
+		end = m[1]
+
+		value, unit := s[m[2]:m[3]], s[m[4]:m[5]]
+		switch unit {
+		case "d", "w":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid age %q: %w", s, err)
+			}
+			day := 24 * time.Hour
+			if unit == "w" {
+				day *= 7
+			}
+			total += time.Duration(n * float64(day))
+		default:
+			d, err := time.ParseDuration(value + unit)
+			if err != nil {
+				return 0, fmt.Errorf("invalid age %q: %w", s, err)
+			}
+			total += d
+		}
+	}
+	if end != len(s) || len(matches) == 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+
+	return total, nil
+}
+
+// FormatAge formats duration using days when it is a whole number of days.
+func FormatAge(d time.Duration) string {
+	day := 24 * time.Hour
+	if d >= day && d%day == 0 {
+		return fmt.Sprintf("%dd", d/day)
+	}
+
+	return d.String()
+}
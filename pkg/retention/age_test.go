@@ -0,0 +1,29 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAge(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"":      0,
+		"72h":   72 * time.Hour,
+		"90d":   90 * 24 * time.Hour,
+		"2w":    14 * 24 * time.Hour,
+		"1d12h": 36 * time.Hour,
+		"1.5d":  36 * time.Hour,
+		"30m":   30 * time.Minute,
+	} {
+		got, err := ParseAge(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{"90", "d", "1y", "1d x", "-1d"} {
+		_, err := ParseAge(in)
+		assert.Error(t, err, in)
+	}
+}
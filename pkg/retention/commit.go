@@ -0,0 +1,70 @@
+package retention
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+)
+
+// shortSHA matches abbreviated commit hashes, git abbreviates to 7 digits at least.
+var shortSHA = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// CommitFunc returns commit hashes, full or abbreviated, an image was built from.
+type CommitFunc func(img images.Image) []string
+
+// CommitsFrom returns CommitFunc reading commits from image tags and the
+// property. Without tagPattern every tag is a commit candidate, otherwise
+// tags must match the pattern and its only group is the commit,
+// e.g. git-sha=(.*). Empty property isn't read.
+func CommitsFrom(tagPattern, property string) (CommitFunc, error) {
+	var re *regexp.Regexp
+	if tagPattern != "" {
+		var err error
+		re, err = regexp.Compile("^(?:" + tagPattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("commit tag pattern: %w", err)
+		}
+		if re.NumSubexp() != 1 {
+			return nil, fmt.Errorf("commit tag pattern %q must have exactly one group", tagPattern)
+		}
+	}
+
+	return func(img images.Image) []string {
+		output := []string{}
+		for _, tag := range img.Tags {
+			if re == nil {
+				output = append(output, tag)
+				continue
+			}
+			if m := re.FindStringSubmatch(tag); m != nil {
+				output = append(output, m[1])
+			}
+		}
+		if v, ok := img.Properties[property]; ok && property != "" {
+			output = append(output, fmt.Sprint(v))
+		}
+		return output
+	}, nil
+}
+
+// matchCommit reports if ref is the commit hash or its abbreviation.
+func matchCommit(ref, commit string) bool {
+	if ref == commit {
+		return true
+	}
+	ref = strings.ToLower(ref)
+
+	return shortSHA.MatchString(ref) && strings.HasPrefix(commit, ref)
+}
+
+// commitRefs returns commits the image was built from, its tags when
+// the set has no CommitRefs.
+func (s *Set) commitRefs(img images.Image) []string {
+	if s.CommitRefs == nil {
+		return img.Tags
+	}
+
+	return s.CommitRefs(img)
+}
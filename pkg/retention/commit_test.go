@@ -0,0 +1,54 @@
+package retention
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommitDepth(t *testing.T) {
+	set := &Set{
+		Commits: []string{
+			"ad6fed9464ef6f47b2d89ab856090d25c898d259",
+			"f8b453a8b9dd6fd431577a47ec48f4ecf1500689",
+		},
+	}
+
+	for tag, want := range map[string]int{
+		"f8b453a8b9dd6fd431577a47ec48f4ecf1500689": 1,
+		"f8b453a8":   1,
+		"AD6FED9":    0,
+		"ad6fed":     -1,
+		"master":     -1,
+		"f8b453a8ff": -1,
+	} {
+		depth, _ := set.CommitDepth(images.Image{Tags: []string{tag}})
+		assert.Equal(t, want, depth, tag)
+	}
+
+	depth, commit := set.CommitDepth(images.Image{Tags: []string{"f8b453a8", "ad6fed94"}})
+	assert.Equal(t, 0, depth)
+	assert.Equal(t, "ad6fed9464ef6f47b2d89ab856090d25c898d259", commit)
+}
+
+func TestCommitsFrom(t *testing.T) {
+	img := images.Image{
+		Tags:       []string{"master", "git-sha=ad6fed94", "sha:f8b453a8"},
+		Properties: map[string]interface{}{"git_sha": "26190eb1"},
+	}
+
+	refs, err := CommitsFrom("", "")
+	require.NoError(t, err)
+	assert.Equal(t, img.Tags, refs(img))
+
+	refs, err = CommitsFrom("git-sha=(.*)", "git_sha")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ad6fed94", "26190eb1"}, refs(img))
+
+	_, err = CommitsFrom("git-sha=.*", "")
+	assert.EqualError(t, err, `commit tag pattern "git-sha=.*" must have exactly one group`)
+	_, err = CommitsFrom("sha:(", "")
+	assert.ErrorContains(t, err, "commit tag pattern: ")
+}
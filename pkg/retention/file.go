@@ -0,0 +1,160 @@
+package retention
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// policyFile is a declarative policy, rules are evaluated in order.
+//
+//	rules:
+//	  - type: public
+//	  - type: age
+//	    min: 72h
+//	  - type: branch
+//	    branches: [main, release/*]
+//	  - type: semver
+//	    patches: 2
+//	    minors: 3
+//	    from: name
+//	  - type: keep-last
+//	    count: 3
+//	  - type: property
+//	    key: stage
+//	    value: release
+//	    action: keep
+//	default: delete
+type policyFile struct {
+	Rules   []ruleSpec `yaml:"rules"`
+	Default string     `yaml:"default"`
+}
+
+type ruleSpec struct {
+	Type     string   `yaml:"type"`
+	Count    int      `yaml:"count"`
+	Min      string   `yaml:"min"`
+	Max      string   `yaml:"max"`
+	Key      string   `yaml:"key"`
+	Value    string   `yaml:"value"`
+	Action   string   `yaml:"action"`
+	Branches []string `yaml:"branches"`
+	Patterns []string `yaml:"patterns"`
+	Patches  int      `yaml:"patches"`
+	Minors   int      `yaml:"minors"`
+	From     string   `yaml:"from"`
+}
+
+// Load reads policy from YAML file.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("policy %s: %w", path, err)
+	}
+
+	return p, nil
+}
+
+// Parse parses YAML policy, unknown fields are rejected to catch typos.
+func Parse(data []byte) (*Policy, error) {
+	var spec policyFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&spec); err != nil {
+		return nil, err
+	}
+	if len(spec.Rules) == 0 {
+		return nil, fmt.Errorf("no rules defined")
+	}
+
+	p := &Policy{
+		Rules: make([]Rule, 0, len(spec.Rules)),
+	}
+	var err error
+	if p.Default, err = parseVerdict(spec.Default, Delete); err != nil {
+		return nil, fmt.Errorf("default: %w", err)
+	}
+
+	for idx, rs := range spec.Rules {
+		r, err := rs.rule()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", idx+1, rs.Type, err)
+		}
+		p.Rules = append(p.Rules, r)
+	}
+
+	return p, nil
+}
+
+func (rs ruleSpec) rule() (Rule, error) {
+	switch rs.Type {
+	case RulePublic:
+		return Public(), nil
+	case RuleProtected:
+		return Protected(), nil
+	case RuleLatest:
+		return Latest(), nil
+	case RuleCommitInHistory:
+		return CommitInHistory(), nil
+	case RuleGitTag:
+		return GitTag(rs.Patterns...), nil
+	case RuleBranch:
+		return Branch(rs.Branches), nil
+	case RuleSemver:
+		if rs.Patches < 1 || rs.Minors < 1 {
+			return nil, fmt.Errorf("patches and minors must be positive")
+		}
+		from := rs.From
+		if from == "" {
+			from = "name"
+		}
+		version, err := VersionFrom(from)
+		if err != nil {
+			return nil, err
+		}
+		return Semver(version, rs.Patches, rs.Minors), nil
+	case RuleKeepLast:
+		if rs.Count < 1 {
+			return nil, fmt.Errorf("count must be positive")
+		}
+		return KeepLast(rs.Count), nil
+	case RuleAge:
+		if (rs.Min == "") == (rs.Max == "") {
+			return nil, fmt.Errorf("exactly one of min or max is required")
+		}
+		if rs.Min != "" {
+			age, err := ParseAge(rs.Min)
+			return MinAge(age), err
+		}
+		age, err := ParseAge(rs.Max)
+		return MaxAge(age), err
+	case RuleProperty:
+		if rs.Key == "" {
+			return nil, fmt.Errorf("key is required")
+		}
+		verdict, err := parseVerdict(rs.Action, Keep)
+		return Property(rs.Key, rs.Value, verdict), err
+	default:
+		return nil, fmt.Errorf("unknown rule type %q", rs.Type)
+	}
+}
+
+func parseVerdict(s string, fallback Verdict) (Verdict, error) {
+	switch s {
+	case "":
+		return fallback, nil
+	case "keep":
+		return Keep, nil
+	case "delete":
+		return Delete, nil
+	default:
+		return Skip, fmt.Errorf("unknown action %q, expected keep or delete", s)
+	}
+}
@@ -0,0 +1,260 @@
+package retention
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"golang.org/x/exp/slices"
+)
+
+// Verdict is an opinion of a rule about an image.
+type Verdict int
+
+const (
+	// Skip means the rule has no opinion, the next rule decides.
+	Skip Verdict = iota
+	// Keep saves the image.
+	Keep
+	// Delete marks the image for deletion.
+	Delete
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Keep:
+		return "keep"
+	case Delete:
+		return "delete"
+	default:
+		return "skip"
+	}
+}
+
+// DefaultRule is the name recorded when no rule decided about an image.
+const DefaultRule = "default"
+
+// Result is a verdict of a rule with a human readable reason.
+type Result struct {
+	Verdict Verdict
+	Reason  string
+}
+
+// Decision is the final verdict about an image and the rule which made it.
+type Decision struct {
+	Image  images.Image
+	Keep   bool
+	Rule   string
+	Reason string
+}
+
+// Decision returns "keep" or "delete".
+func (d Decision) Decision() string {
+	if d.Keep {
+		return Keep.String()
+	}
+
+	return Delete.String()
+}
+
+// Set is a group of images evaluated together, like all images with the same name.
+type Set struct {
+	Images []images.Image
+	// Commits are commit hashes from HEAD, the newest first.
+	Commits []string
+	// GitTags map names of git tags of the repository to commit hashes.
+	GitTags map[string]string
+	// Branch is the current git branch.
+	Branch string
+	// CommitRefs reads commits of images, image tags are used when nil.
+	CommitRefs CommitFunc
+	Now        time.Time
+}
+
+// CommitDepth returns position of the newest commit the image was built from,
+// 0 is HEAD. Abbreviated hashes match too. It returns -1 if the image isn't
+// built from a known commit.
+func (s *Set) CommitDepth(img images.Image) (int, string) {
+	depth, commit := -1, ""
+	for _, ref := range s.commitRefs(img) {
+		idx := slices.IndexFunc(s.Commits, func(c string) bool {
+			return matchCommit(ref, c)
+		})
+		if idx != -1 && (depth == -1 || idx < depth) {
+			depth, commit = idx, s.Commits[idx]
+		}
+	}
+
+	return depth, commit
+}
+
+// Private returns non public images sorted from the newest, images created
+// at the same time are ordered by commit depth.
+func (s *Set) Private() []images.Image {
+	output := []images.Image{}
+	for _, i := range s.Images {
+		if i.Visibility != images.ImageVisibilityPublic {
+			output = append(output, i)
+		}
+	}
+	s.sortNewest(output)
+
+	return output
+}
+
+func (s *Set) sortNewest(imgs []images.Image) {
+	sort.SliceStable(imgs, func(a, b int) bool {
+		if !imgs[a].CreatedAt.Equal(imgs[b].CreatedAt) {
+			return imgs[a].CreatedAt.After(imgs[b].CreatedAt)
+		}
+		da, _ := s.CommitDepth(imgs[a])
+		db, _ := s.CommitDepth(imgs[b])
+		if da == -1 || db == -1 {
+			return da > db
+		}
+		return da < db
+	})
+}
+
+func (s *Set) now() time.Time {
+	if s.Now.IsZero() {
+		return time.Now()
+	}
+
+	return s.Now
+}
+
+// Rule evaluates a set of images and returns results for images it has opinion about.
+type Rule interface {
+	Name() string
+	Evaluate(set *Set) map[string]Result
+}
+
+// Policy is an ordered list of rules, the first rule with an opinion
+// about an image decides its fate.
+type Policy struct {
+	Rules []Rule
+	// Default is the verdict for images no rule decided about, Delete when unset.
+	Default Verdict
+}
+
+// Evaluate returns decisions for all images of set in the same order.
+func (p *Policy) Evaluate(set *Set) []Decision {
+	results := make([]map[string]Result, 0, len(p.Rules))
+	for _, r := range p.Rules {
+		results = append(results, r.Evaluate(set))
+	}
+
+	decisions := make([]Decision, 0, len(set.Images))
+	for _, i := range set.Images {
+		d := Decision{
+			Image:  i,
+			Keep:   p.Default == Keep,
+			Rule:   DefaultRule,
+			Reason: "not kept by any rule",
+		}
+		if d.Keep {
+			d.Reason = "not deleted by any rule"
+		}
+
+		for idx, r := range p.Rules {
+			result, ok := results[idx][i.ID]
+			if !ok || result.Verdict == Skip {
+				continue
+			}
+			d.Keep = result.Verdict == Keep
+			d.Rule = r.Name()
+			d.Reason = result.Reason
+			break
+		}
+		decisions = append(decisions, d)
+	}
+	supersede(set, decisions)
+
+	return decisions
+}
+
+// supersede explains images deleted by default with the newest kept private image.
+func supersede(set *Set, decisions []Decision) {
+	kept := make(map[string]bool, len(decisions))
+	for _, d := range decisions {
+		kept[d.Image.ID] = d.Keep
+	}
+
+	newest := ""
+	for _, i := range set.Private() {
+		if kept[i.ID] {
+			newest = i.ID
+			break
+		}
+	}
+	if newest == "" {
+		return
+	}
+
+	for idx := range decisions {
+		if decisions[idx].Rule == DefaultRule && !decisions[idx].Keep {
+			decisions[idx].Reason = "superseded by " + newest
+		}
+	}
+}
+
+// Has reports if the policy contains a rule with name.
+func (p *Policy) Has(name string) bool {
+	return slices.IndexFunc(p.Rules, func(r Rule) bool { return r.Name() == name }) != -1
+}
+
+// DefaultOptions configure the default policy.
+type DefaultOptions struct {
+	KeepLast int
+	MinAge   time.Duration
+	MaxAge   time.Duration
+	// BranchAware adds Branch rule with heads of Branches.
+	BranchAware bool
+	Branches    []string
+	// KeepTags adds GitTag rule for tags matching the patterns.
+	KeepTags []string
+	// KeepPatches adds Semver rule keeping KeepPatches patches of KeepMinors
+	// minor versions read by Version.
+	KeepPatches int
+	KeepMinors  int
+	Version     VersionFunc
+	// Unprotect leaves protected images to the other rules, they are kept otherwise.
+	Unprotect bool
+}
+
+// NewDefaultPolicy returns policy which keeps public and protected images,
+// images of git tags matching KeepTags, newest patch versions, images
+// younger than MinAge, images of other branches and the newest image of
+// the current branch and branch heads when BranchAware, the newest image,
+// KeepLast newest images, deletes images older than MaxAge and keeps the
+// newest image built from a recent commit.
+func NewDefaultPolicy(opts DefaultOptions) *Policy {
+	rules := []Rule{
+		Public(),
+	}
+	if !opts.Unprotect {
+		rules = append(rules, Protected())
+	}
+	if len(opts.KeepTags) > 0 {
+		rules = append(rules, GitTag(opts.KeepTags...))
+	}
+	if opts.KeepPatches > 0 && opts.Version != nil {
+		rules = append(rules, Semver(opts.Version, opts.KeepPatches, opts.KeepMinors))
+	}
+	rules = append(rules, MinAge(opts.MinAge))
+	if opts.BranchAware {
+		rules = append(rules, Branch(opts.Branches))
+	}
+	rules = append(rules,
+		Latest(),
+		KeepLast(opts.KeepLast),
+		MaxAge(opts.MaxAge),
+		CommitInHistory(),
+	)
+
+	return &Policy{
+		Rules:   rules,
+		Default: Delete,
+	}
+}
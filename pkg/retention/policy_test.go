@@ -0,0 +1,154 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyFirstRuleWins(t *testing.T) {
+	now := time.Now()
+	set := &Set{
+		Images: []images.Image{
+			{ID: "new", CreatedAt: now, Properties: map[string]interface{}{"stage": "test"}},
+			{ID: "release", CreatedAt: now.Add(-time.Hour), Properties: map[string]interface{}{"stage": "release"}},
+			{ID: "old", CreatedAt: now.Add(-2 * time.Hour), Tags: []string{"abc123"}},
+			{ID: "public", CreatedAt: now.Add(-3 * time.Hour), Visibility: images.ImageVisibilityPublic},
+		},
+		Commits: []string{"def456", "abc123"},
+		Now:     now,
+	}
+	p := &Policy{
+		Rules: []Rule{
+			Public(),
+			Property("stage", "release", Keep),
+			Property("stage", "test", Delete),
+			Latest(),
+			CommitInHistory(),
+		},
+	}
+
+	decisions := p.Evaluate(set)
+
+	require.Len(t, decisions, 4)
+	assert.Equal(t, Decision{Image: set.Images[0], Keep: false, Rule: RuleProperty, Reason: "property stage=test"}, decisions[0])
+	assert.Equal(t, Decision{Image: set.Images[1], Keep: true, Rule: RuleProperty, Reason: "property stage=release"}, decisions[1])
+	assert.Equal(t, Decision{Image: set.Images[2], Keep: true, Rule: RuleCommitInHistory, Reason: "matches commit abc123 at depth 1"}, decisions[2])
+	assert.Equal(t, Decision{Image: set.Images[3], Keep: true, Rule: RulePublic, Reason: "public"}, decisions[3])
+}
+
+func TestPolicyDefault(t *testing.T) {
+	set := &Set{Images: []images.Image{{ID: "a"}}}
+
+	assert.False(t, (&Policy{}).Evaluate(set)[0].Keep)
+	assert.Equal(t, DefaultRule, (&Policy{}).Evaluate(set)[0].Rule)
+	assert.True(t, (&Policy{Default: Keep}).Evaluate(set)[0].Keep)
+}
+
+func TestPolicySuperseded(t *testing.T) {
+	now := time.Now()
+	set := &Set{
+		Images: []images.Image{
+			{ID: "new", CreatedAt: now},
+			{ID: "old", CreatedAt: now.Add(-time.Hour)},
+		},
+	}
+
+	decisions := (&Policy{Rules: []Rule{Latest()}}).Evaluate(set)
+
+	assert.Equal(t, "newest", decisions[0].Reason)
+	assert.Equal(t, "superseded by new", decisions[1].Reason)
+}
+
+func TestParse(t *testing.T) {
+	p, err := Parse([]byte(`
+rules:
+  - type: public
+  - type: age
+    min: 3d
+  - type: keep-last
+    count: 2
+  - type: property
+    key: stage
+    value: release
+  - type: git-tag
+default: keep
+`))
+
+	require.NoError(t, err)
+	names := []string{}
+	for _, r := range p.Rules {
+		names = append(names, r.Name())
+	}
+	assert.Equal(t, []string{RulePublic, RuleAge, RuleKeepLast, RuleProperty, RuleGitTag}, names)
+	assert.Equal(t, Keep, p.Default)
+	assert.True(t, p.Has(RuleGitTag))
+	assert.False(t, p.Has(RuleLatest))
+}
+
+func TestParseErrors(t *testing.T) {
+	for in, want := range map[string]string{
+		"rules: []":                                                   "no rules defined",
+		"rules: [{type: newest}]":                                     `rule 1 (newest): unknown rule type "newest"`,
+		"rules: [{type: keep-last}]":                                  "rule 1 (keep-last): count must be positive",
+		"rules: [{type: age, min: 1d, max: 2d}]":                      "rule 1 (age): exactly one of min or max is required",
+		"rules: [{type: property, key: a, action: no}]":               `rule 1 (property): unknown action "no", expected keep or delete`,
+		"rules: [{type: public}]\ndefault: maybe":                     `default: unknown action "maybe", expected keep or delete`,
+		"rules: [{type: semver, patches: 1}]":                         "rule 1 (semver): patches and minors must be positive",
+		"rules: [{type: semver, patches: 1, minors: 1, from: label}]": `rule 1 (semver): unknown version source "label", expected name, tag or property:<key>`,
+	} {
+		_, err := Parse([]byte(in))
+		assert.EqualError(t, err, want, in)
+	}
+
+	_, err := Parse([]byte("rules: [{type: public, count: 1, typo: 1}]"))
+	assert.Error(t, err)
+}
+
+func TestBranch(t *testing.T) {
+	now := time.Now()
+	set := &Set{
+		Images: []images.Image{
+			{ID: "main-new", Tags: []string{"main"}, CreatedAt: now},
+			{ID: "main-old", Tags: []string{"main"}, CreatedAt: now.Add(-time.Hour)},
+			{ID: "release-new", Tags: []string{"release/1.2"}, CreatedAt: now.Add(-2 * time.Hour)},
+			{ID: "release-old", Tags: []string{"release/1.2"}, CreatedAt: now.Add(-3 * time.Hour)},
+			{ID: "feature", Tags: []string{"feature/x"}, CreatedAt: now.Add(-4 * time.Hour)},
+		},
+		Branch: "main",
+	}
+
+	results := Branch([]string{"release/*"}).Evaluate(set)
+
+	assert.Equal(t, Result{Keep, "newest of branch main"}, results["main-new"])
+	assert.NotContains(t, results, "main-old")
+	assert.Equal(t, Result{Keep, "newest of branch release/1.2"}, results["release-new"])
+	assert.NotContains(t, results, "release-old")
+	assert.Equal(t, Result{Keep, "not built from current branch main"}, results["feature"])
+
+	set.Branch = ""
+	assert.Empty(t, Branch(nil).Evaluate(set))
+}
+
+func TestGitTag(t *testing.T) {
+	set := &Set{
+		Images: []images.Image{
+			{ID: "by-name", Tags: []string{"v1.0"}},
+			{ID: "by-commit", Tags: []string{"abc123"}},
+			{ID: "rc", Tags: []string{"rc-1"}},
+			{ID: "untagged"},
+		},
+		GitTags: map[string]string{"v1.0": "def456", "v1.1": "abc123", "rc-1": "aaa111"},
+	}
+
+	results := GitTag("v*").Evaluate(set)
+
+	assert.Equal(t, Result{Keep, "tagged with git tag v1.0"}, results["by-name"])
+	assert.Equal(t, Result{Keep, "built from git tag v1.1 at abc123"}, results["by-commit"])
+	assert.Equal(t, Result{}, results["rc"])
+	assert.Equal(t, Result{}, results["untagged"])
+	assert.Equal(t, Result{Keep, "tagged with git tag rc-1"}, GitTag().Evaluate(set)["rc"])
+}
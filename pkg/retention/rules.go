@@ -0,0 +1,240 @@
+package retention
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// Rule names used in policy files.
+const (
+	RulePublic          = "public"
+	RuleProtected       = "protected"
+	RuleLatest          = "latest"
+	RuleKeepLast        = "keep-last"
+	RuleCommitInHistory = "commit-in-history"
+	RuleGitTag          = "git-tag"
+	RuleAge             = "age"
+	RuleProperty        = "property"
+	RuleBranch          = "branch"
+	RuleSemver          = "semver"
+)
+
+// ruleFunc adapts a function to the Rule interface.
+type ruleFunc struct {
+	name     string
+	evaluate func(set *Set) map[string]Result
+}
+
+func (r ruleFunc) Name() string {
+	return r.name
+}
+
+func (r ruleFunc) Evaluate(set *Set) map[string]Result {
+	return r.evaluate(set)
+}
+
+// eachImage builds a rule which judges every image on its own.
+func eachImage(name string, fn func(set *Set, img images.Image) Result) Rule {
+	return ruleFunc{
+		name: name,
+		evaluate: func(set *Set) map[string]Result {
+			output := make(map[string]Result, len(set.Images))
+			for _, i := range set.Images {
+				output[i.ID] = fn(set, i)
+			}
+			return output
+		},
+	}
+}
+
+// Public keeps public images.
+func Public() Rule {
+	return eachImage(RulePublic, func(_ *Set, img images.Image) Result {
+		if img.Visibility == images.ImageVisibilityPublic {
+			return Result{Keep, "public"}
+		}
+		return Result{}
+	})
+}
+
+// Protected keeps protected images.
+func Protected() Rule {
+	return eachImage(RuleProtected, func(_ *Set, img images.Image) Result {
+		if img.Protected {
+			return Result{Keep, "protected"}
+		}
+		return Result{}
+	})
+}
+
+// Latest keeps the newest private image.
+func Latest() Rule {
+	return ruleFunc{
+		name: RuleLatest,
+		evaluate: func(set *Set) map[string]Result {
+			private := set.Private()
+			if len(private) == 0 {
+				return nil
+			}
+			return map[string]Result{
+				private[0].ID: {Keep, "newest"},
+			}
+		},
+	}
+}
+
+// KeepLast keeps n newest private images.
+func KeepLast(n int) Rule {
+	return ruleFunc{
+		name: RuleKeepLast,
+		evaluate: func(set *Set) map[string]Result {
+			output := map[string]Result{}
+			for idx, i := range set.Private() {
+				if idx >= n {
+					break
+				}
+				output[i.ID] = Result{Keep, fmt.Sprintf("one of %d newest", n)}
+			}
+			return output
+		},
+	}
+}
+
+// CommitInHistory keeps the newest image built from a commit of the scanned history.
+func CommitInHistory() Rule {
+	return ruleFunc{
+		name: RuleCommitInHistory,
+		evaluate: func(set *Set) map[string]Result {
+			for _, i := range set.Private() {
+				depth, commit := set.CommitDepth(i)
+				if depth == -1 {
+					continue
+				}
+				return map[string]Result{
+					i.ID: {Keep, fmt.Sprintf("matches commit %s at depth %d", commit, depth)},
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// Branch keeps the newest image of the current branch and of every branch
+// matching heads and images of other branches, so only older images of the
+// current branch and of the heads are left to the next rules. Branches are
+// image tags, heads are path.Match patterns like release/*. The rule has no
+// opinion when the current branch is unknown.
+func Branch(heads []string) Rule {
+	return ruleFunc{
+		name: RuleBranch,
+		evaluate: func(set *Set) map[string]Result {
+			if set.Branch == "" {
+				return nil
+			}
+
+			output := map[string]Result{}
+			newest := map[string]bool{}
+			for _, i := range set.Private() {
+				if slices.Contains(i.Tags, set.Branch) {
+					if !newest[set.Branch] {
+						newest[set.Branch] = true
+						output[i.ID] = Result{Keep, "newest of branch " + set.Branch}
+					}
+					continue
+				}
+
+				branch := headBranch(i.Tags, heads)
+				switch {
+				case branch == "":
+					output[i.ID] = Result{Keep, "not built from current branch " + set.Branch}
+				case !newest[branch]:
+					newest[branch] = true
+					output[i.ID] = Result{Keep, "newest of branch " + branch}
+				}
+			}
+			return output
+		},
+	}
+}
+
+// headBranch returns the first tag matching one of heads.
+func headBranch(tags, heads []string) string {
+	for _, tag := range tags {
+		if matchAny(tag, heads) {
+			return tag
+		}
+	}
+
+	return ""
+}
+
+// GitTag keeps images tagged with a name of a git tag or built from the
+// commit the tag points to. Only tags matching one of path.Match patterns are
+// used, all tags without patterns.
+func GitTag(patterns ...string) Rule {
+	return eachImage(RuleGitTag, func(set *Set, img images.Image) Result {
+		names := maps.Keys(set.GitTags)
+		slices.Sort(names)
+		for _, name := range names {
+			if len(patterns) > 0 && !matchAny(name, patterns) {
+				continue
+			}
+			commit := set.GitTags[name]
+			if slices.Contains(img.Tags, name) {
+				return Result{Keep, "tagged with git tag " + name}
+			}
+			for _, ref := range set.commitRefs(img) {
+				if matchCommit(ref, commit) {
+					return Result{Keep, fmt.Sprintf("built from git tag %s at %s", name, commit)}
+				}
+			}
+		}
+		return Result{}
+	})
+}
+
+func matchAny(s string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MinAge keeps images younger than age, zero age disables the rule.
+func MinAge(age time.Duration) Rule {
+	return eachImage(RuleAge, func(set *Set, img images.Image) Result {
+		if age > 0 && set.now().Sub(img.CreatedAt) < age {
+			return Result{Keep, fmt.Sprintf("younger than %s", FormatAge(age))}
+		}
+		return Result{}
+	})
+}
+
+// MaxAge deletes images older than age, zero age disables the rule.
+func MaxAge(age time.Duration) Rule {
+	return eachImage(RuleAge, func(set *Set, img images.Image) Result {
+		if age > 0 && set.now().Sub(img.CreatedAt) > age {
+			return Result{Delete, fmt.Sprintf("older than %s", FormatAge(age))}
+		}
+		return Result{}
+	})
+}
+
+// Property applies verdict to images with property key equal to value.
+func Property(key, value string, verdict Verdict) Rule {
+	return eachImage(RuleProperty, func(_ *Set, img images.Image) Result {
+		v, ok := img.Properties[key]
+		if !ok || fmt.Sprint(v) != value {
+			return Result{}
+		}
+		return Result{verdict, fmt.Sprintf("property %s=%s", key, value)}
+	})
+}
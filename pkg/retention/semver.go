@@ -0,0 +1,145 @@
+package retention
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+)
+
+// versionRe matches the numeric part of a semantic version, e.g. 16.2.2 in gitlab_dev_16.2.2.
+var versionRe = regexp.MustCompile(`v?(\d+)\.(\d+)\.(\d+)`)
+
+// Version is a semantic version without pre-release and build metadata.
+type Version struct {
+	Major, Minor, Patch int
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// minor returns major.minor of the version.
+func (v Version) minor() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+func (v Version) less(o Version) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+	return v.Patch < o.Patch
+}
+
+// ParseVersion returns the last semantic version found in s.
+func ParseVersion(s string) (Version, bool) {
+	matches := versionRe.FindAllStringSubmatch(s, -1)
+	if len(matches) == 0 {
+		return Version{}, false
+	}
+
+	m := matches[len(matches)-1]
+	var v Version
+	for idx, dst := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		n, err := strconv.Atoi(m[idx+1])
+		if err != nil {
+			return Version{}, false
+		}
+		*dst = n
+	}
+
+	return v, true
+}
+
+// StripVersion removes semantic versions from s, gitlab_dev_16.2.2 becomes gitlab_dev_.
+func StripVersion(s string) string {
+	return versionRe.ReplaceAllString(s, "")
+}
+
+// VersionFunc reads version of an image.
+type VersionFunc func(img images.Image) (Version, bool)
+
+// VersionFrom returns VersionFunc reading versions from "name", "tag"
+// or "property:<key>" of images.
+func VersionFrom(source string) (VersionFunc, error) {
+	kind, key, _ := strings.Cut(source, ":")
+	switch {
+	case source == "name":
+		return func(img images.Image) (Version, bool) {
+			return ParseVersion(img.Name)
+		}, nil
+	case source == "tag":
+		return func(img images.Image) (Version, bool) {
+			var newest Version
+			found := false
+			for _, tag := range img.Tags {
+				if v, ok := ParseVersion(tag); ok && (!found || newest.less(v)) {
+					newest, found = v, true
+				}
+			}
+			return newest, found
+		}, nil
+	case kind == "property" && key != "":
+		return func(img images.Image) (Version, bool) {
+			v, ok := img.Properties[key]
+			if !ok {
+				return Version{}, false
+			}
+			return ParseVersion(fmt.Sprint(v))
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown version source %q, expected name, tag or property:<key>", source)
+	}
+}
+
+// Semver keeps the newest image of each of patches newest patch versions
+// of minors newest minor versions. Images without version are skipped.
+func Semver(version VersionFunc, patches, minors int) Rule {
+	return ruleFunc{
+		name: RuleSemver,
+		evaluate: func(set *Set) map[string]Result {
+			// newest image of every version, images are sorted from the newest
+			newest := map[Version]images.Image{}
+			for _, i := range set.Private() {
+				if v, ok := version(i); ok {
+					if _, seen := newest[v]; !seen {
+						newest[v] = i
+					}
+				}
+			}
+
+			versions := make([]Version, 0, len(newest))
+			for v := range newest {
+				versions = append(versions, v)
+			}
+			sort.Slice(versions, func(a, b int) bool {
+				return versions[b].less(versions[a])
+			})
+
+			output := map[string]Result{}
+			keptMinors := []string{}
+			keptPatches := 0
+			for _, v := range versions {
+				if len(keptMinors) == 0 || keptMinors[len(keptMinors)-1] != v.minor() {
+					if len(keptMinors) == minors {
+						break
+					}
+					keptMinors = append(keptMinors, v.minor())
+					keptPatches = 0
+				}
+				if keptPatches == patches {
+					continue
+				}
+				keptPatches++
+				output[newest[v].ID] = Result{Keep, fmt.Sprintf("version %s is one of %d newest patches of %s", v, patches, v.minor())}
+			}
+			return output
+		},
+	}
+}
@@ -0,0 +1,78 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersion(t *testing.T) {
+	for in, want := range map[string]Version{
+		"gitlab_dev_16.2.2":      {16, 2, 2},
+		"v1.10.0":                {1, 10, 0},
+		"runner_1.2.3-rc1":       {1, 2, 3},
+		"ubuntu-22.04.1_16.2.10": {16, 2, 10},
+	} {
+		got, ok := ParseVersion(in)
+		assert.True(t, ok, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{"", "gitlab_dev", "runner_17.7"} {
+		_, ok := ParseVersion(in)
+		assert.False(t, ok, in)
+	}
+
+	assert.Equal(t, "gitlab_dev_", StripVersion("gitlab_dev_16.2.2"))
+}
+
+func TestVersionFrom(t *testing.T) {
+	img := images.Image{
+		Name:       "gitlab_dev_16.2.2",
+		Tags:       []string{"v16.1.0", "v16.3.1", "abc123"},
+		Properties: map[string]interface{}{"version": "16.4.0"},
+	}
+	for source, want := range map[string]Version{
+		"name":             {16, 2, 2},
+		"tag":              {16, 3, 1},
+		"property:version": {16, 4, 0},
+	} {
+		version, err := VersionFrom(source)
+		require.NoError(t, err, source)
+		got, ok := version(img)
+		assert.True(t, ok, source)
+		assert.Equal(t, want, got, source)
+	}
+
+	_, err := VersionFrom("label")
+	assert.EqualError(t, err, `unknown version source "label", expected name, tag or property:<key>`)
+}
+
+func TestSemver(t *testing.T) {
+	now := time.Now()
+	set := &Set{
+		Images: []images.Image{
+			{ID: "16.2.2-rebuild", Name: "gitlab_16.2.2", CreatedAt: now},
+			{ID: "16.2.2", Name: "gitlab_16.2.2", CreatedAt: now.Add(-time.Hour)},
+			{ID: "16.2.1", Name: "gitlab_16.2.1", CreatedAt: now.Add(-2 * time.Hour)},
+			{ID: "16.2.0", Name: "gitlab_16.2.0", CreatedAt: now.Add(-3 * time.Hour)},
+			{ID: "16.1.5", Name: "gitlab_16.1.5", CreatedAt: now.Add(-4 * time.Hour)},
+			{ID: "16.0.9", Name: "gitlab_16.0.9", CreatedAt: now.Add(-5 * time.Hour)},
+			{ID: "unversioned", Name: "gitlab", CreatedAt: now.Add(-6 * time.Hour)},
+		},
+	}
+	version, err := VersionFrom("name")
+	require.NoError(t, err)
+
+	results := Semver(version, 2, 2).Evaluate(set)
+
+	assert.Equal(t, Result{Keep, "version 16.2.2 is one of 2 newest patches of 16.2"}, results["16.2.2-rebuild"])
+	assert.Equal(t, Result{Keep, "version 16.2.1 is one of 2 newest patches of 16.2"}, results["16.2.1"])
+	assert.Equal(t, Result{Keep, "version 16.1.5 is one of 2 newest patches of 16.1"}, results["16.1.5"])
+	for _, id := range []string{"16.2.2", "16.2.0", "16.0.9", "unversioned"} {
+		assert.Equal(t, Result{}, results[id], id)
+	}
+}
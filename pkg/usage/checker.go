@@ -0,0 +1,12 @@
+package usage
+
+import (
+	"context"
+)
+
+// Checker finds resources of the project using an image, implemented by Compute.
+type Checker interface {
+	// InUse returns a description of a resource using the image like
+	// "server web-1", an empty string means the image isn't used.
+	InUse(ctx context.Context, imageID string) (string, error)
+}
@@ -0,0 +1,91 @@
+package usage
+
+import (
+	"context"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/pagination"
+)
+
+var _ Checker = (*Compute)(nil)
+
+// Compute is a Checker backed by the Nova API and optionally by the Cinder
+// API. Servers are filtered by image on every call, volumes are listed once.
+type Compute struct {
+	compute *gophercloud.ServiceClient
+	volume  *gophercloud.ServiceClient
+	volumes map[string]string
+}
+
+// NewCompute returns a Checker of servers, volumes created from images are
+// checked too unless volume client is nil.
+func NewCompute(compute, volume *gophercloud.ServiceClient) *Compute {
+	return &Compute{
+		compute: compute,
+		volume:  volume,
+	}
+}
+
+// InUse returns the first server booted from the image or the first volume
+// created from it.
+func (c *Compute) InUse(ctx context.Context, imageID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	user := ""
+	err := servers.List(c.compute, servers.ListOpts{Image: imageID, Limit: 1}).EachPage(func(page pagination.Page) (bool, error) {
+		list, err := servers.ExtractServers(page)
+		if err != nil {
+			return false, err
+		}
+		if len(list) > 0 {
+			user = "server " + name(list[0].Name, list[0].ID)
+		}
+		return false, nil
+	})
+	if err != nil || user != "" || c.volume == nil {
+		return user, err
+	}
+
+	if c.volumes == nil {
+		if err := c.listVolumes(); err != nil {
+			return "", err
+		}
+	}
+
+	return c.volumes[imageID], nil
+}
+
+// listVolumes maps images to the first volume created from them.
+func (c *Compute) listVolumes() error {
+	allPages, err := volumes.List(c.volume, volumes.ListOpts{}).AllPages()
+	if err != nil {
+		return err
+	}
+	list, err := volumes.ExtractVolumes(allPages)
+	if err != nil {
+		return err
+	}
+
+	c.volumes = make(map[string]string, len(list))
+	for _, v := range list {
+		id := v.VolumeImageMetadata["image_id"]
+		if _, ok := c.volumes[id]; id != "" && !ok {
+			c.volumes[id] = "volume " + name(v.Name, v.ID)
+		}
+	}
+
+	return nil
+}
+
+// name returns the name of a resource or its id when the name is empty.
+func name(name, id string) string {
+	if name == "" {
+		return id
+	}
+
+	return name
+}
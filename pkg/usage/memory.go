@@ -0,0 +1,20 @@
+package usage
+
+import (
+	"context"
+)
+
+var _ Checker = Memory(nil)
+
+// Memory is an in-memory Checker mapping image ids to their users,
+// it is intended for tests.
+type Memory map[string]string
+
+// InUse returns the user of the image.
+func (m Memory) InUse(ctx context.Context, imageID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	return m[imageID], nil
+}